@@ -0,0 +1,38 @@
+package filter
+
+import (
+	"github.com/dmage/switchemu/hw"
+)
+
+// Classifier sends packets that match the filter to Match and all other
+// packets to NoMatch.
+type Classifier struct {
+	Filter  *Filter
+	Match   hw.Handler
+	NoMatch hw.Handler
+
+	Matched         int64
+	MatchedBytes    int64
+	NotMatched      int64
+	NotMatchedBytes int64
+}
+
+func NewClassifier(f *Filter, match hw.Handler, noMatch hw.Handler) *Classifier {
+	return &Classifier{
+		Filter:  f,
+		Match:   match,
+		NoMatch: noMatch,
+	}
+}
+
+func (c *Classifier) HandlePacket(w *hw.World, p *hw.Packet) {
+	if c.Filter.Match(p.CapturedData, p.Length) {
+		c.Matched++
+		c.MatchedBytes += int64(p.Length)
+		c.Match.HandlePacket(w, p)
+	} else {
+		c.NotMatched++
+		c.NotMatchedBytes += int64(p.Length)
+		c.NoMatch.HandlePacket(w, p)
+	}
+}
@@ -0,0 +1,553 @@
+// Package filter implements tcpdump-style packet filter expressions in pure
+// Go.
+//
+// The supported primitives are
+//
+//	[src|dst] host ADDR       [src|dst] net PREFIX      [src|dst] port PORT
+//	[src|dst] portrange A-B   ether [src|dst] host MAC  ether proto N
+//	ip  ip6  tcp  udp  sctp  icmp  icmp6  arp
+//	ip proto N  ip6 proto N  proto N  vlan [ID]
+//	less LEN  greater LEN  broadcast  multicast
+//
+// Host, net and port primitives may be qualified by a protocol (for
+// example "ip6 src host ::1" or "tcp dst port 443"), and a direction may
+// also be "src or dst" or "src and dst". Primitives can be combined with
+// and (&&), or (||), not (!) and parentheses. As in tcpdump, a bare value
+// after and/or repeats the previous primitive: "port 80 or 443". An IPv4
+// net without a prefix length covers the octets given: "net 10" is
+// 10.0.0.0/8.
+package filter
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/dmage/switchemu/hw"
+)
+
+type packet struct {
+	data    []byte
+	length  int
+	headers hw.Headers
+	ok      bool
+}
+
+type matchFunc func(p *packet) bool
+
+// Filter is a compiled filter expression. A Filter is not safe for
+// concurrent use. If Decapsulate is set, the expression is matched against
+// the inner headers of tunnel packets; Ethernet address primitives always
+// refer to the outer frame.
+type Filter struct {
+	Decapsulate bool
+
+	expr  string
+	match matchFunc
+	p     packet
+}
+
+// Compile parses a filter expression.
+func Compile(expr string) (*Filter, error) {
+	p := &parser{tokens: tokenize(expr)}
+	var m matchFunc
+	if len(p.tokens) == 0 {
+		m = func(*packet) bool { return true }
+	} else {
+		var err error
+		m, err = p.parseOr()
+		if err != nil {
+			return nil, fmt.Errorf("filter %q: %s", expr, err)
+		}
+		if p.pos != len(p.tokens) {
+			return nil, fmt.Errorf("filter %q: unexpected %q", expr, p.tokens[p.pos])
+		}
+	}
+	return &Filter{
+		expr:  expr,
+		match: m,
+	}, nil
+}
+
+// MustCompile is like Compile but panics if the expression cannot be
+// parsed.
+func MustCompile(expr string) *Filter {
+	f, err := Compile(expr)
+	if err != nil {
+		panic(err)
+	}
+	return f
+}
+
+func (f *Filter) String() string {
+	return f.expr
+}
+
+// Match reports whether the frame data, whose length on the wire is length,
+// matches the filter.
+func (f *Filter) Match(data []byte, length int) bool {
+	f.p.data = data
+	f.p.length = length
+	f.p.ok = f.p.headers.ParseFor(data, f.Decapsulate)
+	return f.match(&f.p)
+}
+
+func tokenize(expr string) []string {
+	var tokens []string
+	i := 0
+	for i < len(expr) {
+		c := expr[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c == '(' || c == ')':
+			tokens = append(tokens, string(c))
+			i++
+		case c == '!' && (i+1 == len(expr) || expr[i+1] != '='):
+			tokens = append(tokens, "!")
+			i++
+		case strings.HasPrefix(expr[i:], "&&") || strings.HasPrefix(expr[i:], "||"):
+			tokens = append(tokens, expr[i:i+2])
+			i += 2
+		default:
+			j := i
+			for j < len(expr) && !strings.ContainsRune(" \t\n()!&|", rune(expr[j])) {
+				j++
+			}
+			tokens = append(tokens, expr[i:j])
+			i = j
+		}
+	}
+	return tokens
+}
+
+// qualifiers of a primitive, remembered for tcpdump's implicit repetition
+// ("host a or b").
+type qualifiers struct {
+	proto string
+	dir   string
+	typ   string
+}
+
+type parser struct {
+	tokens []string
+	pos    int
+	last   qualifiers
+}
+
+func (p *parser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *parser) next() (string, error) {
+	if p.pos >= len(p.tokens) {
+		return "", fmt.Errorf("unexpected end of expression")
+	}
+	t := p.tokens[p.pos]
+	p.pos++
+	return t, nil
+}
+
+func (p *parser) parseOr() (matchFunc, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "or" || p.peek() == "||" {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(pkt *packet) bool { return l(pkt) || right(pkt) }
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (matchFunc, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "and" || p.peek() == "&&" {
+		p.pos++
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(pkt *packet) bool { return l(pkt) && right(pkt) }
+	}
+	return left, nil
+}
+
+func (p *parser) parseNot() (matchFunc, error) {
+	if p.peek() == "not" || p.peek() == "!" {
+		p.pos++
+		m, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return func(pkt *packet) bool { return !m(pkt) }, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (matchFunc, error) {
+	if p.peek() == "(" {
+		p.pos++
+		m, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if t, err := p.next(); err != nil || t != ")" {
+			return nil, fmt.Errorf("expected )")
+		}
+		return m, nil
+	}
+	return p.parsePrimitive()
+}
+
+var protoNames = map[string]uint8{
+	"icmp":   hw.ProtocolICMP,
+	"tcp":    hw.ProtocolTCP,
+	"udp":    hw.ProtocolUDP,
+	"icmp6":  hw.ProtocolICMPv6,
+	"sctp":   hw.ProtocolSCTP,
+	"esp":    50,
+	"ah":     51,
+	"gre":    hw.ProtocolGRE,
+	"igmp":   2,
+	"pim":    103,
+	"vrrp":   112,
+	"ipv6":   hw.ProtocolIPv6,
+	"ipip":   hw.ProtocolIPv4,
+	"ospf":   89,
+	"icmpv6": hw.ProtocolICMPv6,
+}
+
+var portNames = map[string]uint16{
+	"ftp":    21,
+	"ssh":    22,
+	"telnet": 23,
+	"smtp":   25,
+	"domain": 53,
+	"http":   80,
+	"ntp":    123,
+	"bgp":    179,
+	"https":  443,
+}
+
+func isDir(t string) bool {
+	return t == "src" || t == "dst"
+}
+
+func isType(t string) bool {
+	return t == "host" || t == "net" || t == "port" || t == "portrange"
+}
+
+func (p *parser) parsePrimitive() (matchFunc, error) {
+	t, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+
+	switch t {
+	case "ip", "ip6", "tcp", "udp", "sctp", "ether":
+		next := p.peek()
+		if t == "ether" || isDir(next) || isType(next) {
+			return p.parseQualified(qualifiers{proto: t})
+		}
+		if (t == "ip" || t == "ip6") && next == "proto" {
+			p.pos++
+			return p.parseProto(t)
+		}
+		return protoMatch(t), nil
+	case "icmp", "icmp6", "arp":
+		return protoMatch(t), nil
+	case "proto":
+		return p.parseProto("")
+	case "vlan":
+		if id, err := strconv.ParseUint(p.peek(), 10, 12); err == nil {
+			p.pos++
+			return func(pkt *packet) bool {
+				return pkt.headers.HasVLAN && pkt.headers.VLAN == uint16(id)
+			}, nil
+		}
+		return func(pkt *packet) bool { return pkt.headers.HasVLAN }, nil
+	case "less", "greater":
+		v, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid length %q", v)
+		}
+		if t == "less" {
+			return func(pkt *packet) bool { return pkt.length <= n }, nil
+		}
+		return func(pkt *packet) bool { return pkt.length >= n }, nil
+	case "broadcast":
+		return etherBroadcast, nil
+	case "multicast":
+		return etherMulticast, nil
+	case "src", "dst", "host", "net", "port", "portrange":
+		p.pos--
+		return p.parseQualified(qualifiers{})
+	}
+
+	// A bare value repeats the qualifiers of the previous primitive.
+	if p.last.typ != "" || p.last.proto == "ether" {
+		p.pos--
+		return p.parseValue(p.last)
+	}
+	return nil, fmt.Errorf("unknown primitive %q", t)
+}
+
+func (p *parser) parseProto(family string) (matchFunc, error) {
+	v, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	proto, ok := protoNames[v]
+	if !ok {
+		n, err := strconv.ParseUint(v, 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("unknown protocol %q", v)
+		}
+		proto = uint8(n)
+	}
+	fm := familyMatch(family)
+	return func(pkt *packet) bool {
+		return pkt.ok && fm(pkt) && pkt.headers.Protocol == proto
+	}, nil
+}
+
+func (p *parser) parseQualified(q qualifiers) (matchFunc, error) {
+	if isDir(p.peek()) {
+		q.dir = p.tokens[p.pos]
+		p.pos++
+		// "src or dst" and "src and dst"
+		if p.pos+1 < len(p.tokens) && (p.peek() == "or" || p.peek() == "and") && isDir(p.tokens[p.pos+1]) {
+			q.dir = q.dir + " " + p.peek() + " " + p.tokens[p.pos+1]
+			p.pos += 2
+		}
+	}
+	if q.proto == "ether" {
+		switch p.peek() {
+		case "broadcast":
+			p.pos++
+			return etherBroadcast, nil
+		case "multicast":
+			p.pos++
+			return etherMulticast, nil
+		case "proto":
+			p.pos++
+			v, err := p.next()
+			if err != nil {
+				return nil, err
+			}
+			n, err := strconv.ParseUint(v, 0, 16)
+			if err != nil {
+				return nil, fmt.Errorf("invalid ether proto %q", v)
+			}
+			return func(pkt *packet) bool { return pkt.headers.EtherType == uint16(n) }, nil
+		}
+	}
+	if isType(p.peek()) {
+		q.typ = p.tokens[p.pos]
+		p.pos++
+	}
+	if q.typ == "" && q.proto != "ether" {
+		q.typ = "host"
+	}
+	return p.parseValue(q)
+}
+
+// parseIPv4Net parses a network without a prefix length. As in tcpdump,
+// the prefix length follows from the number of octets given: "10" is
+// 10.0.0.0/8, "172.16" is 172.16.0.0/16 and "192.0.2" is 192.0.2.0/24.
+func parseIPv4Net(v string) (*net.IPNet, error) {
+	parts := strings.Split(v, ".")
+	if len(parts) > 4 {
+		return nil, fmt.Errorf("invalid network %q", v)
+	}
+	ip := make(net.IP, 4)
+	for i, part := range parts {
+		n, err := strconv.ParseUint(part, 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q", v)
+		}
+		ip[i] = byte(n)
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(8*len(parts), 32)}, nil
+}
+
+func (p *parser) parseValue(q qualifiers) (matchFunc, error) {
+	p.last = q
+
+	v, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+
+	if q.proto == "ether" {
+		mac, err := net.ParseMAC(v)
+		if err != nil {
+			return nil, err
+		}
+		return dirMatch(q.dir, func(pkt *packet, src bool) bool {
+			if len(pkt.data) < 12 {
+				return false
+			}
+			if src {
+				return bytes.Equal(pkt.data[6:12], mac)
+			}
+			return bytes.Equal(pkt.data[0:6], mac)
+		})
+	}
+
+	fm := familyMatch(q.proto)
+	switch q.typ {
+	case "host", "net":
+		var ipnet *net.IPNet
+		if q.typ == "net" && !strings.Contains(v, "/") && !strings.Contains(v, ":") {
+			ipnet, err = parseIPv4Net(v)
+			if err != nil {
+				return nil, err
+			}
+		} else if q.typ == "host" || !strings.Contains(v, "/") {
+			ip := net.ParseIP(v)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address %q", v)
+			}
+			bits := 128
+			if ip4 := ip.To4(); ip4 != nil {
+				ip = ip4
+				bits = 32
+			}
+			ipnet = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+		} else {
+			_, ipnet, err = net.ParseCIDR(v)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return dirMatch(q.dir, func(pkt *packet, src bool) bool {
+			if !pkt.ok || !fm(pkt) {
+				return false
+			}
+			ip := pkt.headers.DstIP
+			if src {
+				ip = pkt.headers.SrcIP
+			}
+			return len(ip) == len(ipnet.IP) && ipnet.Contains(ip)
+		})
+	case "port", "portrange":
+		lo, hi, err := parsePortRange(v, q.typ == "portrange")
+		if err != nil {
+			return nil, err
+		}
+		var proto uint8
+		switch q.proto {
+		case "tcp", "udp", "sctp":
+			proto = protoNames[q.proto]
+			fm = familyMatch("")
+		}
+		return dirMatch(q.dir, func(pkt *packet, src bool) bool {
+			if !pkt.ok || !pkt.headers.HasPorts || !fm(pkt) {
+				return false
+			}
+			if proto != 0 && pkt.headers.Protocol != proto {
+				return false
+			}
+			port := pkt.headers.DstPort
+			if src {
+				port = pkt.headers.SrcPort
+			}
+			return port >= lo && port <= hi
+		})
+	}
+	return nil, fmt.Errorf("unexpected %q", v)
+}
+
+func parsePortRange(v string, isRange bool) (uint16, uint16, error) {
+	parsePort := func(s string) (uint16, error) {
+		if n, ok := portNames[s]; ok {
+			return n, nil
+		}
+		n, err := strconv.ParseUint(s, 10, 16)
+		if err != nil {
+			return 0, fmt.Errorf("invalid port %q", s)
+		}
+		return uint16(n), nil
+	}
+	if !isRange {
+		port, err := parsePort(v)
+		return port, port, err
+	}
+	parts := strings.SplitN(v, "-", 2)
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid port range %q", v)
+	}
+	lo, err := parsePort(parts[0])
+	if err != nil {
+		return 0, 0, err
+	}
+	hi, err := parsePort(parts[1])
+	if err != nil {
+		return 0, 0, err
+	}
+	return lo, hi, nil
+}
+
+func dirMatch(dir string, m func(pkt *packet, src bool) bool) (matchFunc, error) {
+	switch dir {
+	case "src":
+		return func(pkt *packet) bool { return m(pkt, true) }, nil
+	case "dst":
+		return func(pkt *packet) bool { return m(pkt, false) }, nil
+	case "", "src or dst":
+		return func(pkt *packet) bool { return m(pkt, true) || m(pkt, false) }, nil
+	case "src and dst":
+		return func(pkt *packet) bool { return m(pkt, true) && m(pkt, false) }, nil
+	}
+	return nil, fmt.Errorf("invalid direction %q", dir)
+}
+
+func familyMatch(family string) matchFunc {
+	switch family {
+	case "ip":
+		return func(pkt *packet) bool { return pkt.headers.EtherType == hw.EtherTypeIPv4 }
+	case "ip6":
+		return func(pkt *packet) bool { return pkt.headers.EtherType == hw.EtherTypeIPv6 }
+	}
+	return func(pkt *packet) bool { return true }
+}
+
+func protoMatch(name string) matchFunc {
+	switch name {
+	case "ip", "ip6":
+		fm := familyMatch(name)
+		return func(pkt *packet) bool { return pkt.ok && fm(pkt) }
+	case "arp":
+		return func(pkt *packet) bool { return pkt.headers.EtherType == 0x0806 }
+	}
+	proto := protoNames[name]
+	return func(pkt *packet) bool { return pkt.ok && pkt.headers.Protocol == proto }
+}
+
+func etherBroadcast(pkt *packet) bool {
+	return len(pkt.data) >= 6 && bytes.Equal(pkt.data[0:6], []byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF})
+}
+
+func etherMulticast(pkt *packet) bool {
+	return len(pkt.data) >= 6 && pkt.data[0]&1 != 0
+}
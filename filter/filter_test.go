@@ -0,0 +1,131 @@
+package filter
+
+import (
+	"net"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+func udpFrame(t *testing.T, vlan int, src, dst string, sport, dport uint16) []byte {
+	var ls []gopacket.SerializableLayer
+	eth := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0, 1, 2, 3, 4, 5},
+		DstMAC:       net.HardwareAddr{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF},
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+	ls = append(ls, eth)
+	if vlan >= 0 {
+		eth.EthernetType = layers.EthernetTypeDot1Q
+		ls = append(ls, &layers.Dot1Q{VLANIdentifier: uint16(vlan), Type: layers.EthernetTypeIPv4})
+	}
+	ip4 := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolUDP, SrcIP: net.ParseIP(src), DstIP: net.ParseIP(dst)}
+	if ip4.SrcIP.To4() == nil {
+		for _, l := range ls {
+			switch l := l.(type) {
+			case *layers.Ethernet:
+				if l.EthernetType == layers.EthernetTypeIPv4 {
+					l.EthernetType = layers.EthernetTypeIPv6
+				}
+			case *layers.Dot1Q:
+				l.Type = layers.EthernetTypeIPv6
+			}
+		}
+		ip6 := &layers.IPv6{Version: 6, HopLimit: 64, NextHeader: layers.IPProtocolUDP, SrcIP: net.ParseIP(src), DstIP: net.ParseIP(dst)}
+		udp := &layers.UDP{SrcPort: layers.UDPPort(sport), DstPort: layers.UDPPort(dport)}
+		_ = udp.SetNetworkLayerForChecksum(ip6)
+		ls = append(ls, ip6, udp)
+	} else {
+		udp := &layers.UDP{SrcPort: layers.UDPPort(sport), DstPort: layers.UDPPort(dport)}
+		_ = udp.SetNetworkLayerForChecksum(ip4)
+		ls = append(ls, ip4, udp)
+	}
+	ls = append(ls, gopacket.Payload(make([]byte, 16)))
+
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, ls...); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestFilter(t *testing.T) {
+	v4 := udpFrame(t, -1, "10.0.0.1", "192.168.1.2", 1234, 443)
+	v6 := udpFrame(t, 200, "2001:db8::1", "2001:db8::2", 53, 5353)
+
+	testCases := []struct {
+		expr string
+		v4   bool
+		v6   bool
+	}{
+		{"", true, true},
+		{"ip", true, false},
+		{"ip6", false, true},
+		{"udp", true, true},
+		{"tcp", false, false},
+		{"port 443", true, false},
+		{"udp dst port 443", true, false},
+		{"tcp port 443", false, false},
+		{"src port 443", false, false},
+		{"port 80 or 443", true, false},
+		{"port domain", false, true},
+		{"portrange 5000-6000", false, true},
+		{"host 10.0.0.1", true, false},
+		{"dst host 10.0.0.1", false, false},
+		{"src or dst 192.168.1.2", true, false},
+		{"net 192.168.0.0/16", true, false},
+		{"net 10", true, false},
+		{"dst net 192.168.1", true, false},
+		{"net 192.168", true, false},
+		{"net 10.0.0.0", false, false},
+		{"src net 192.168", false, false},
+		{"src net 2001:db8::/32 and dst net 2001:db8::/32", false, true},
+		{"ip6 host 10.0.0.1", false, false},
+		{"vlan", false, true},
+		{"vlan 200", false, true},
+		{"not vlan 200", true, false},
+		{"!(vlan 200) && udp", true, false},
+		{"ip proto udp", true, false},
+		{"proto 17", true, true},
+		{"ether src 00:01:02:03:04:05", true, true},
+		{"ether broadcast", true, true},
+		{"greater 70", false, true},
+		{"less 70", true, false},
+		{"arp or icmp", false, false},
+	}
+	for _, tc := range testCases {
+		f, err := Compile(tc.expr)
+		if err != nil {
+			t.Errorf("%q: %s", tc.expr, err)
+			continue
+		}
+		if got := f.Match(v4, len(v4)); got != tc.v4 {
+			t.Errorf("%q: ipv4 packet: got %t, want %t", tc.expr, got, tc.v4)
+		}
+		if got := f.Match(v6, len(v6)); got != tc.v6 {
+			t.Errorf("%q: ipv6 packet: got %t, want %t", tc.expr, got, tc.v6)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	for _, expr := range []string{
+		"foo",
+		"host",
+		"host 300.0.0.1",
+		"(tcp",
+		"tcp)",
+		"port x",
+		"portrange 10",
+		"udp and",
+		"net 10.x",
+		"net 1.2.3.4.5",
+		"net 256",
+	} {
+		if _, err := Compile(expr); err == nil {
+			t.Errorf("%q: expected an error", expr)
+		}
+	}
+}
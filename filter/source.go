@@ -0,0 +1,37 @@
+package filter
+
+import (
+	"github.com/google/gopacket"
+)
+
+// Source is a packet data source that only returns packets matching the
+// filter. It lets a Receiver see a subset of a capture file as if the other
+// packets had never been recorded.
+type Source struct {
+	Source gopacket.PacketDataSource
+	Filter *Filter
+
+	Matched  int64
+	Filtered int64
+}
+
+func NewSource(source gopacket.PacketDataSource, f *Filter) *Source {
+	return &Source{
+		Source: source,
+		Filter: f,
+	}
+}
+
+func (s *Source) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	for {
+		data, ci, err := s.Source.ReadPacketData()
+		if err != nil {
+			return data, ci, err
+		}
+		if s.Filter.Match(data, ci.Length) {
+			s.Matched++
+			return data, ci, nil
+		}
+		s.Filtered++
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/dmage/switchemu/gen"
+	"github.com/dmage/switchemu/hw"
+)
+
+// fitCommand fits a traffic model to capture files. The model can be
+// replayed with -generate "model file=...".
+func fitCommand(args []string) {
+	fs := flag.NewFlagSet("fit", flag.ExitOnError)
+	output := fs.String("o", "traffic.model", "write the model to `file`")
+	idleTimeout := fs.Duration("flow-timeout", time.Second, "end flows that have been idle for `duration`")
+	quantiles := fs.Int("quantiles", 101, "describe the fitted distributions by `n` quantiles (at least 2)")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s fit [flags] file.pcap...\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if *quantiles < 2 {
+		log.Fatal("quantiles must be at least 2")
+	}
+
+	w := &hw.World{}
+	fitter := gen.NewFitter(*idleTimeout, hw.NullHandler{})
+	fitter.Quantiles = *quantiles
+	for _, filename := range fs.Args() {
+		h, f := openCapture(filename)
+		defer f.Close()
+		hw.NewReceiver(w, h, h.LinkType(), 40*1000*1000*1000, fitter)
+	}
+	w.Simulate()
+
+	model := fitter.Model()
+	f, err := os.Create(*output)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := model.Write(f); err != nil {
+		log.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("fitted %d packets in %s, %.0f flows/s, written to %s", model.Packets, model.Duration, model.FlowRate, *output)
+}
@@ -0,0 +1,75 @@
+package gen
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
+)
+
+// AddressPool is a set of prefixes from which addresses are picked
+// uniformly. All prefixes of a pool belong to the same address family.
+type AddressPool struct {
+	Prefixes []*net.IPNet
+}
+
+// ParseAddressPool parses a comma-separated list of prefixes and addresses,
+// e.g. "10.0.0.0/24,10.0.1.7".
+func ParseAddressPool(s string) (*AddressPool, error) {
+	pool := &AddressPool{}
+	for _, item := range strings.Split(s, ",") {
+		var prefix *net.IPNet
+		if strings.IndexByte(item, '/') != -1 {
+			var err error
+			_, prefix, err = net.ParseCIDR(item)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address %q", item)
+			}
+			if ip4 := ip.To4(); ip4 != nil {
+				ip = ip4
+			}
+			prefix = &net.IPNet{IP: ip, Mask: net.CIDRMask(8*len(ip), 8*len(ip))}
+		}
+		if len(pool.Prefixes) != 0 && len(prefix.IP) != len(pool.Prefixes[0].IP) {
+			return nil, fmt.Errorf("address pool %q mixes IPv4 and IPv6", s)
+		}
+		pool.Prefixes = append(pool.Prefixes, prefix)
+	}
+	return pool, nil
+}
+
+// IPv6 reports whether the pool contains IPv6 addresses.
+func (pool *AddressPool) IPv6() bool {
+	return len(pool.Prefixes[0].IP) == net.IPv6len
+}
+
+// Pick returns a random address of the pool. Every prefix is picked with
+// the same probability.
+func (pool *AddressPool) Pick(r *rand.Rand) net.IP {
+	prefix := pool.Prefixes[r.Intn(len(pool.Prefixes))]
+	ip := make(net.IP, len(prefix.IP))
+	for i := range ip {
+		ip[i] = prefix.IP[i] | byte(r.Intn(256))&^prefix.Mask[i]
+	}
+	return ip
+}
+
+// Nth returns the address with the index i in the first prefix of the
+// pool. Indexes wrap around at the size of the prefix.
+func (pool *AddressPool) Nth(i int) net.IP {
+	prefix := pool.Prefixes[0]
+	ip := make(net.IP, len(prefix.IP))
+	copy(ip, prefix.IP)
+	carry := uint64(i)
+	for j := len(ip) - 1; j >= 0 && carry != 0; j-- {
+		v := uint64(ip[j]) + carry&0xFF
+		carry = carry>>8 + v>>8
+		ip[j] = prefix.IP[j] | byte(v)&^prefix.Mask[j]
+	}
+	return ip
+}
@@ -0,0 +1,176 @@
+package gen
+
+import (
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/dmage/switchemu/hw"
+)
+
+// reservoir keeps a uniform random sample of a stream of values.
+type reservoir struct {
+	values []float64
+	n      int64
+	size   int
+	rand   *rand.Rand
+}
+
+func (s *reservoir) add(v float64) {
+	s.n++
+	if len(s.values) < s.size {
+		s.values = append(s.values, v)
+		return
+	}
+	if i := s.rand.Int63n(s.n); i < int64(s.size) {
+		s.values[i] = v
+	}
+}
+
+type flowStats struct {
+	last    time.Duration
+	packets int64
+}
+
+// Fitter is a handler that fits a Model to the packets that pass it. Flows
+// are identified by their 5-tuple and end after they have been idle for
+// IdleTimeout. Packets that are not IPv4 or IPv6 packets count only
+// towards the sizes.
+type Fitter struct {
+	IdleTimeout time.Duration
+	Output      hw.Handler
+
+	// Quantiles is the number of quantiles of the fitted distributions.
+	// It must be at least 2, smaller values are treated as 2.
+	Quantiles int
+
+	first, last time.Duration
+	packets     int64
+	bytes       int64
+	flows       map[string]*flowStats
+	nextExpire  time.Duration
+	started     int64
+	tcp         int64
+	ipv6        int64
+	dsts        map[string]int64
+
+	sizes       reservoir
+	flowPackets reservoir
+	gaps        reservoir
+
+	headers hw.Headers
+	key     []byte
+}
+
+func NewFitter(idleTimeout time.Duration, output hw.Handler) *Fitter {
+	r := rand.New(rand.NewSource(1))
+	return &Fitter{
+		IdleTimeout: idleTimeout,
+		Output:      output,
+		Quantiles:   101,
+		flows:       make(map[string]*flowStats),
+		dsts:        make(map[string]int64),
+		sizes:       reservoir{size: 100000, rand: r},
+		flowPackets: reservoir{size: 100000, rand: r},
+		gaps:        reservoir{size: 100000, rand: r},
+	}
+}
+
+// expire ends the flows that have been idle since before t.
+func (f *Fitter) expire(t time.Duration) {
+	for key, fs := range f.flows {
+		if fs.last < t {
+			f.flowPackets.add(float64(fs.packets))
+			delete(f.flows, key)
+		}
+	}
+}
+
+func (f *Fitter) HandlePacket(w *hw.World, p *hw.Packet) {
+	now := w.Time()
+	if f.packets == 0 {
+		f.first = now
+		f.nextExpire = now + f.IdleTimeout
+	}
+	f.last = now
+	f.packets++
+	f.bytes += int64(p.Length)
+	f.sizes.add(float64(p.Length))
+
+	if now >= f.nextExpire {
+		f.expire(now - f.IdleTimeout)
+		f.nextExpire = now + f.IdleTimeout
+	}
+
+	if f.headers.Parse(p.CapturedData) {
+		h := &f.headers
+		key := append(f.key[:0], h.SrcIP...)
+		key = append(key, h.DstIP...)
+		key = append(key, h.Protocol, byte(h.SrcPort>>8), byte(h.SrcPort), byte(h.DstPort>>8), byte(h.DstPort))
+		f.key = key
+
+		fs, ok := f.flows[string(key)]
+		if ok && now-fs.last > f.IdleTimeout {
+			f.flowPackets.add(float64(fs.packets))
+			ok = false
+		}
+		if ok {
+			f.gaps.add(float64(now - fs.last))
+			fs.packets++
+			fs.last = now
+		} else {
+			f.flows[string(key)] = &flowStats{last: now, packets: 1}
+			f.started++
+			if h.Protocol == hw.ProtocolTCP {
+				f.tcp++
+			}
+			if h.EtherType == hw.EtherTypeIPv6 {
+				f.ipv6++
+			}
+			f.dsts[string(h.DstIP)]++
+		}
+	}
+
+	f.Output.HandlePacket(w, p)
+}
+
+// Model returns the model of the packets. It ends the flows that are still
+// active, so it is called after the last packet.
+func (f *Fitter) Model() *Model {
+	m := &Model{
+		Duration: f.last - f.first,
+		Packets:  f.packets,
+		Bytes:    f.bytes,
+	}
+	if f.packets == 0 {
+		return m
+	}
+
+	for _, fs := range f.flows {
+		f.flowPackets.add(float64(fs.packets))
+	}
+	f.flows = make(map[string]*flowStats)
+
+	if f.started != 0 {
+		duration := m.Duration
+		if duration <= 0 {
+			duration = time.Second
+		}
+		m.FlowRate = float64(f.started) / duration.Seconds()
+		m.TCP = float64(f.tcp) / float64(f.started)
+		m.IPv6 = float64(f.ipv6) / float64(f.started)
+	}
+
+	m.Sizes = fitQuantiles(f.sizes.values, f.Quantiles)
+	m.FlowPackets = fitQuantiles(f.flowPackets.values, f.Quantiles)
+	m.Gaps = fitQuantiles(f.gaps.values, f.Quantiles)
+
+	for _, n := range f.dsts {
+		m.Destinations = append(m.Destinations, float64(n))
+	}
+	sort.Sort(sort.Reverse(sort.Float64Slice(m.Destinations)))
+	if len(m.Destinations) > 1000 {
+		m.Destinations = m.Destinations[:1000]
+	}
+	return m
+}
@@ -0,0 +1,195 @@
+// Package gen generates synthetic traffic and scales captured traffic.
+// Generators and transforms are packet data sources like capture files, so
+// they can feed a hw.Receiver.
+package gen
+
+import (
+	"io"
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// Source is a packet data source of synthetic traffic. Capture file readers
+// satisfy it too, so inputs of both kinds can be handled alike.
+type Source interface {
+	gopacket.PacketDataSource
+	LinkType() layers.LinkType
+}
+
+// PortRange is an inclusive range of transport ports.
+type PortRange struct {
+	Lo uint16
+	Hi uint16
+}
+
+func (pr PortRange) pick(r *rand.Rand) uint16 {
+	return pr.Lo + uint16(r.Intn(int(pr.Hi)-int(pr.Lo)+1))
+}
+
+type flow struct {
+	src, dst         net.IP
+	srcPort, dstPort uint16
+}
+
+// Generator emits Ethernet frames with UDP or TCP packets. The arrival
+// times are drawn from Process, the frame sizes from Sizes and the
+// addresses from Src and Dst. Frames that are smaller than their headers
+// are padded.
+//
+// If Flows is positive, packets belong to Flows flows that are chosen at
+// the start; otherwise every packet gets random addresses and ports. The
+// generator stops after Duration or after Count packets, whatever comes
+// first; zero means no limit.
+type Generator struct {
+	Process  Process
+	Sizes    SizeDist
+	Src      *AddressPool
+	Dst      *AddressPool
+	SrcPorts PortRange
+	DstPorts PortRange
+	Protocol layers.IPProtocol
+	Flows    int
+
+	Start    time.Time
+	Duration time.Duration
+	Count    int
+
+	rand     *rand.Rand
+	flows    []flow
+	t        time.Duration
+	n        int
+	prevSize int
+	builder  builder
+}
+
+// NewGenerator returns a generator of UDP packets with random ports. The
+// same seed produces the same packets.
+func NewGenerator(process Process, sizes SizeDist, src, dst *AddressPool, seed int64) *Generator {
+	return &Generator{
+		Process:  process,
+		Sizes:    sizes,
+		Src:      src,
+		Dst:      dst,
+		SrcPorts: PortRange{Lo: 1024, Hi: 65535},
+		DstPorts: PortRange{Lo: 1024, Hi: 65535},
+		Protocol: layers.IPProtocolUDP,
+		rand:     rand.New(rand.NewSource(seed)),
+	}
+}
+
+func (g *Generator) LinkType() layers.LinkType {
+	return layers.LinkTypeEthernet
+}
+
+func (g *Generator) newFlow() flow {
+	return flow{
+		src:     g.Src.Pick(g.rand),
+		dst:     g.Dst.Pick(g.rand),
+		srcPort: g.SrcPorts.pick(g.rand),
+		dstPort: g.DstPorts.pick(g.rand),
+	}
+}
+
+// ReadPacketData returns the next packet. The returned data is valid until
+// the next call.
+func (g *Generator) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	if g.Count > 0 && g.n >= g.Count {
+		return nil, gopacket.CaptureInfo{}, io.EOF
+	}
+	if g.n > 0 {
+		g.t += g.Process.Next(g.rand, g.prevSize)
+	}
+	if g.Duration > 0 && g.t >= g.Duration {
+		return nil, gopacket.CaptureInfo{}, io.EOF
+	}
+
+	if g.Flows > 0 && g.flows == nil {
+		for i := 0; i < g.Flows; i++ {
+			g.flows = append(g.flows, g.newFlow())
+		}
+	}
+	var f flow
+	if g.flows != nil {
+		f = g.flows[g.rand.Intn(len(g.flows))]
+	} else {
+		f = g.newFlow()
+	}
+
+	data, err := g.builder.build(f, g.Protocol, g.Sizes.Size(g.rand))
+	if err != nil {
+		return nil, gopacket.CaptureInfo{}, err
+	}
+	g.n++
+	g.prevSize = len(data)
+	return data, gopacket.CaptureInfo{
+		Timestamp:     g.Start.Add(g.t),
+		CaptureLength: len(data),
+		Length:        len(data),
+	}, nil
+}
+
+var (
+	srcMAC = net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x01}
+	dstMAC = net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x02}
+)
+
+// builder serializes frames into a reused buffer.
+type builder struct {
+	buf     gopacket.SerializeBuffer
+	payload []byte
+}
+
+// build serializes a frame of the flow f with size bytes.
+func (b *builder) build(f flow, protocol layers.IPProtocol, size int) ([]byte, error) {
+	if b.buf == nil {
+		b.buf = gopacket.NewSerializeBuffer()
+	}
+
+	eth := &layers.Ethernet{SrcMAC: srcMAC, DstMAC: dstMAC}
+	var ip gopacket.SerializableLayer
+	var network gopacket.NetworkLayer
+	headerSize := 14
+	if len(f.src) == net.IPv6len {
+		eth.EthernetType = layers.EthernetTypeIPv6
+		ip6 := &layers.IPv6{Version: 6, HopLimit: 64, NextHeader: protocol, SrcIP: f.src, DstIP: f.dst}
+		ip, network = ip6, ip6
+		headerSize += 40
+	} else {
+		eth.EthernetType = layers.EthernetTypeIPv4
+		ip4 := &layers.IPv4{Version: 4, IHL: 5, TTL: 64, Protocol: protocol, SrcIP: f.src, DstIP: f.dst}
+		ip, network = ip4, ip4
+		headerSize += 20
+	}
+
+	var l4 gopacket.SerializableLayer
+	switch protocol {
+	case layers.IPProtocolTCP:
+		tcp := &layers.TCP{SrcPort: layers.TCPPort(f.srcPort), DstPort: layers.TCPPort(f.dstPort), ACK: true, Window: 65535, DataOffset: 5}
+		tcp.SetNetworkLayerForChecksum(network)
+		l4 = tcp
+		headerSize += 20
+	default:
+		udp := &layers.UDP{SrcPort: layers.UDPPort(f.srcPort), DstPort: layers.UDPPort(f.dstPort)}
+		udp.SetNetworkLayerForChecksum(network)
+		l4 = udp
+		headerSize += 8
+	}
+
+	payloadSize := size - headerSize
+	if payloadSize < 0 {
+		payloadSize = 0
+	}
+	if len(b.payload) < payloadSize {
+		b.payload = make([]byte, payloadSize)
+	}
+	err := gopacket.SerializeLayers(b.buf, gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true},
+		eth, ip, l4, gopacket.Payload(b.payload[:payloadSize]))
+	if err != nil {
+		return nil, err
+	}
+	return b.buf.Bytes(), nil
+}
@@ -0,0 +1,178 @@
+package gen
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/dmage/switchemu/hw"
+	"github.com/google/gopacket"
+)
+
+var epoch = time.Unix(0, 0)
+
+// readAll returns the timestamps and the total size of the packets of g.
+func readAll(t *testing.T, g gopacket.PacketDataSource) ([]time.Time, int64) {
+	var timestamps []time.Time
+	var bytes int64
+	for {
+		data, ci, err := g.ReadPacketData()
+		if err == io.EOF {
+			return timestamps, bytes
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(data) != ci.Length {
+			t.Fatalf("got %d bytes of data, want %d", len(data), ci.Length)
+		}
+		timestamps = append(timestamps, ci.Timestamp)
+		bytes += int64(ci.Length)
+	}
+}
+
+func TestCBR(t *testing.T) {
+	g, err := Parse("cbr rate=1G size=1250 count=1000 src=192.0.2.1 dst=198.51.100.0/24", epoch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	timestamps, _ := readAll(t, g)
+	if len(timestamps) != 1000 {
+		t.Fatalf("got %d packets, want 1000", len(timestamps))
+	}
+	if d := timestamps[999].Sub(timestamps[0]); d != 999*10*time.Microsecond {
+		t.Errorf("got %s between the first and the last packet, want 9.99ms", d)
+	}
+}
+
+func TestGeneratorRates(t *testing.T) {
+	testCases := []struct {
+		spec string
+		rate float64 // bits per second
+	}{
+		{"poisson rate=1G size=1000 duration=100ms", 1e9},
+		{"poisson rate=2G size=imix duration=100ms", 2e9},
+		{"mmpp rates=1G,3G dwell=1ms,1ms size=64-1518 duration=1s", 2e9},
+	}
+	for _, tc := range testCases {
+		g, err := Parse(tc.spec, epoch)
+		if err != nil {
+			t.Fatalf("%s: %s", tc.spec, err)
+		}
+		_, bytes := readAll(t, g)
+		rate := float64(bytes) * 8 / g.(*Generator).Duration.Seconds()
+		if math.Abs(rate-tc.rate)/tc.rate > 0.05 {
+			t.Errorf("%s: got %.3g bit/s, want %.3g", tc.spec, rate, tc.rate)
+		}
+	}
+}
+
+func TestGeneratorPackets(t *testing.T) {
+	spec := "onoff rate=10G size=imix src=2001:db8::/64 dst=2001:db8:1::1 proto=tcp dport=443 flows=4 count=100 seed=7"
+	g1, err := Parse(spec, epoch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g2, _ := Parse(spec, epoch)
+
+	flows := make(map[uint16]bool)
+	for i := 0; i < 100; i++ {
+		data1, ci1, _ := g1.ReadPacketData()
+		data2, ci2, _ := g2.ReadPacketData()
+		if !bytes.Equal(data1, data2) || !ci1.Timestamp.Equal(ci2.Timestamp) {
+			t.Fatalf("packet %d: generators with the same seed differ", i)
+		}
+
+		var h hw.Headers
+		if !h.Parse(data1) {
+			t.Fatalf("packet %d: failed to parse", i)
+		}
+		if h.Protocol != hw.ProtocolTCP || h.DstPort != 443 || h.DstIP.String() != "2001:db8:1::1" {
+			t.Errorf("packet %d: got protocol %d to %s port %d", i, h.Protocol, h.DstIP, h.DstPort)
+		}
+		if len(data1) != 594 && len(data1) != 1518 && len(data1) != 14+40+20 {
+			t.Errorf("packet %d: got %d bytes", i, len(data1))
+		}
+		flows[h.SrcPort] = true
+	}
+	if len(flows) > 4 {
+		t.Errorf("got %d flows, want at most 4", len(flows))
+	}
+}
+
+func TestIncast(t *testing.T) {
+	start := time.Date(2017, 7, 14, 2, 40, 0, 0, time.UTC)
+	g, err := Parse("incast senders=8 burst=15k size=1500 rate=10G interval=1ms jitter=10us count=3 dst=192.0.2.1", start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	timestamps, bytes := readAll(t, g)
+	if len(timestamps) != 3*8*10 || bytes != 3*8*15000 {
+		t.Errorf("got %d packets with %d bytes, want %d packets with %d bytes", len(timestamps), bytes, 3*8*10, 3*8*15000)
+	}
+	for i, ts := range timestamps {
+		if i > 0 && ts.Before(timestamps[i-1]) {
+			t.Fatalf("packet %d: timestamps are not ordered", i)
+		}
+	}
+	// Each burst lasts 10 packets of 1.2us plus the jitter.
+	if first, last := timestamps[0], timestamps[len(timestamps)-1]; first.Before(start) || last.Sub(start) > 2*time.Millisecond+22*time.Microsecond {
+		t.Errorf("got packets from %s to %s, want them within 2.022ms from %s", first, last, start)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	cbr, _ := Parse("cbr rate=1G size=1000 count=50 src=2001:db8::/64 dst=2001:db8:1::1", epoch)
+	incast, _ := Parse("incast senders=4 burst=6k size=1500 interval=100us count=5 proto=tcp src=2001:db8:2::/64 dst=2001:db8:1::2", epoch)
+	m := NewMerge(cbr, incast)
+
+	var prev time.Time
+	n := 0
+	for {
+		data, ci, err := m.ReadPacketData()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ci.Timestamp.Before(prev) {
+			t.Fatalf("packet %d: timestamps are not ordered", n)
+		}
+		prev = ci.Timestamp
+		if len(data) != ci.Length || !bytes.Equal(data, reserialize(t, data)) {
+			t.Fatalf("packet %d: wrong length or checksums", n)
+		}
+		if l := int(data[14+4])<<8 | int(data[14+5]); l != len(data)-14-40 {
+			t.Fatalf("packet %d: got payload length %d, want %d", n, l, len(data)-14-40)
+		}
+		n++
+	}
+	if want := 50 + 5*4*4; n != want {
+		t.Errorf("got %d packets, want %d", n, want)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"burst rate=1G",
+		"cbr rate=fast",
+		"cbr colour=red",
+		"poisson src=10.0.0.0/8 dst=2001:db8::1",
+		"poisson size=100-64",
+		"onoff shape=1",
+		"mmpp rates=1G,2G dwell=1ms",
+		"mmpp rates=0,0 dwell=1ms,1ms count=3",
+		"cbr proto=icmp",
+		"incast senders=0",
+		"incast burst=big",
+		"incast flows=10",
+	} {
+		if _, err := Parse(spec, epoch); err == nil {
+			t.Errorf("%q: expected an error", spec)
+		}
+	}
+}
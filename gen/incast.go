@@ -0,0 +1,226 @@
+package gen
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dmage/switchemu/hw"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// Incast emits synchronized bursts from many senders to one destination,
+// like the responses of servers to a request of a client. Every Interval,
+// each of Senders senders starts a burst of BurstSize bytes after a random
+// delay of up to Jitter. The packets of a burst are PacketSize bytes long
+// and are sent back to back at Rate, the bandwidth of the sender.
+//
+// Every sender keeps its address and ports. The generator stops after
+// Duration or after Count bursts, whatever comes first; zero means no
+// limit.
+type Incast struct {
+	Senders    int
+	BurstSize  int
+	PacketSize int
+	Rate       int64 // bits per second
+	Interval   time.Duration
+	Jitter     time.Duration
+
+	Src      *AddressPool
+	Dst      *AddressPool
+	SrcPorts PortRange
+	DstPorts PortRange
+	Protocol layers.IPProtocol
+
+	Start    time.Time
+	Duration time.Duration
+	Count    int
+
+	// Bursts is the number of bursts that have been started.
+	Bursts int
+
+	rand    *rand.Rand
+	flows   []flow
+	pending incastPackets
+	next    time.Duration
+	builder builder
+}
+
+type incastPacket struct {
+	t      time.Duration
+	sender int
+	size   int
+}
+
+// incastPackets sorts packets by their send times.
+type incastPackets []incastPacket
+
+func (q incastPackets) Len() int           { return len(q) }
+func (q incastPackets) Less(i, j int) bool { return q[i].t < q[j].t }
+func (q incastPackets) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+
+func NewIncast(senders, burstSize, packetSize int, rate int64, interval time.Duration, src, dst *AddressPool, seed int64) *Incast {
+	return &Incast{
+		Senders:    senders,
+		BurstSize:  burstSize,
+		PacketSize: packetSize,
+		Rate:       rate,
+		Interval:   interval,
+		Src:        src,
+		Dst:        dst,
+		SrcPorts:   PortRange{Lo: 1024, Hi: 65535},
+		DstPorts:   PortRange{Lo: 1024, Hi: 65535},
+		Protocol:   layers.IPProtocolUDP,
+		rand:       rand.New(rand.NewSource(seed)),
+	}
+}
+
+func (g *Incast) LinkType() layers.LinkType {
+	return layers.LinkTypeEthernet
+}
+
+func (g *Incast) moreBursts() bool {
+	if g.Count > 0 && g.Bursts >= g.Count {
+		return false
+	}
+	if g.Duration > 0 && g.next >= g.Duration {
+		return false
+	}
+	return g.Bursts == 0 || g.Interval > 0
+}
+
+// addBurst schedules the packets of the next burst.
+func (g *Incast) addBurst() {
+	if g.flows == nil {
+		dst := g.Dst.Pick(g.rand)
+		dstPort := g.DstPorts.pick(g.rand)
+		for i := 0; i < g.Senders; i++ {
+			g.flows = append(g.flows, flow{
+				src:     g.Src.Pick(g.rand),
+				dst:     dst,
+				srcPort: g.SrcPorts.pick(g.rand),
+				dstPort: dstPort,
+			})
+		}
+	}
+
+	for i := range g.flows {
+		t := g.next
+		if g.Jitter > 0 {
+			t += time.Duration(g.rand.Int63n(int64(g.Jitter)))
+		}
+		for left := g.BurstSize; left > 0; left -= g.PacketSize {
+			size := g.PacketSize
+			if left < size {
+				size = left
+			}
+			g.pending = append(g.pending, incastPacket{t: t, sender: i, size: size})
+			t += bitsDuration(size, g.Rate)
+		}
+	}
+	sort.Stable(g.pending)
+	g.Bursts++
+	g.next += g.Interval
+}
+
+// ReadPacketData returns the next packet. The returned data is valid until
+// the next call.
+func (g *Incast) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	// Bursts may overlap, so the next burst is scheduled before any packet
+	// that is sent after it starts.
+	for (len(g.pending) == 0 || g.pending[0].t >= g.next) && g.moreBursts() {
+		g.addBurst()
+	}
+	if len(g.pending) == 0 {
+		return nil, gopacket.CaptureInfo{}, io.EOF
+	}
+
+	p := g.pending[0]
+	g.pending = g.pending[1:]
+	data, err := g.builder.build(g.flows[p.sender], g.Protocol, p.size)
+	if err != nil {
+		return nil, gopacket.CaptureInfo{}, err
+	}
+	return data, gopacket.CaptureInfo{
+		Timestamp:     g.Start.Add(p.t),
+		CaptureLength: len(data),
+		Length:        len(data),
+	}, nil
+}
+
+// parseBytes parses a size in bytes. The value may have one of the
+// suffixes k or M.
+func parseBytes(s string) (int, error) {
+	mult := 1
+	switch {
+	case strings.HasSuffix(s, "k"):
+		mult = 1000
+	case strings.HasSuffix(s, "M"):
+		mult = 1000 * 1000
+	}
+	if mult != 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * mult, nil
+}
+
+func parseIncast(options map[string]string) (*Incast, error) {
+	senders, err := strconv.Atoi(options["senders"])
+	if err != nil || senders < 1 {
+		return nil, fmt.Errorf("invalid number of senders %q", options["senders"])
+	}
+	burst, err := parseBytes(options["burst"])
+	if err != nil {
+		return nil, err
+	}
+	size, err := parseBytes(options["size"])
+	if err != nil {
+		return nil, err
+	}
+	rate, err := hw.ParseBandwidth(options["rate"])
+	if err != nil {
+		return nil, err
+	}
+	interval, err := time.ParseDuration(options["interval"])
+	if err != nil {
+		return nil, err
+	}
+	jitter, err := time.ParseDuration(options["jitter"])
+	if err != nil {
+		return nil, err
+	}
+	if interval < 0 || jitter < 0 {
+		return nil, fmt.Errorf("interval and jitter must not be negative")
+	}
+	src, err := ParseAddressPool(options["src"])
+	if err != nil {
+		return nil, err
+	}
+	dst, err := ParseAddressPool(options["dst"])
+	if err != nil {
+		return nil, err
+	}
+	seed, err := strconv.ParseInt(options["seed"], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid seed %q", options["seed"])
+	}
+
+	g := NewIncast(senders, burst, size, rate, interval, src, dst, seed)
+	g.Jitter = jitter
+	if err := parseFlow(options, g.Src, g.Dst, &g.Protocol, &g.SrcPorts, &g.DstPorts); err != nil {
+		return nil, err
+	}
+	if err := parseLimits(options, &g.Start, &g.Duration, &g.Count); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
@@ -0,0 +1,77 @@
+package gen
+
+import (
+	"container/heap"
+	"io"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// Merge is a source that interleaves the packets of several sources in the
+// order of their timestamps. Packets with equal timestamps are returned in
+// the order of the sources. The link type is the link type of the first
+// source.
+//
+// The data of a packet is valid until the next call of ReadPacketData, as
+// with the sources themselves.
+type Merge struct {
+	Sources []Source
+
+	queue   packetQueue
+	started bool
+	refill  int // the source of the last returned packet, or -1
+}
+
+func NewMerge(sources ...Source) *Merge {
+	return &Merge{
+		Sources: sources,
+		refill:  -1,
+	}
+}
+
+func (m *Merge) LinkType() layers.LinkType {
+	if len(m.Sources) == 0 {
+		return layers.LinkTypeEthernet
+	}
+	return m.Sources[0].LinkType()
+}
+
+// read queues the next packet of the source i.
+func (m *Merge) read(i int) error {
+	data, ci, err := m.Sources[i].ReadPacketData()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	heap.Push(&m.queue, queuedPacket{data: data, ci: ci, seq: int64(i)})
+	return nil
+}
+
+func (m *Merge) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	if !m.started {
+		m.started = true
+		for i := range m.Sources {
+			if err := m.read(i); err != nil {
+				return nil, gopacket.CaptureInfo{}, err
+			}
+		}
+	}
+	// The source of the previous packet is read only now, because reading
+	// it may overwrite the data of that packet.
+	if m.refill >= 0 {
+		i := m.refill
+		m.refill = -1
+		if err := m.read(i); err != nil {
+			return nil, gopacket.CaptureInfo{}, err
+		}
+	}
+	if m.queue.Len() == 0 {
+		return nil, gopacket.CaptureInfo{}, io.EOF
+	}
+	p := heap.Pop(&m.queue).(queuedPacket)
+	m.refill = int(p.seq)
+	return p.data, p.ci, nil
+}
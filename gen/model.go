@@ -0,0 +1,211 @@
+package gen
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Quantiles describes an empirical distribution by equally spaced
+// quantiles, from the minimum to the maximum.
+type Quantiles []float64
+
+// fitQuantiles returns n quantiles of values, at least the minimum and
+// the maximum. It sorts values.
+func fitQuantiles(values []float64, n int) Quantiles {
+	if len(values) == 0 {
+		return nil
+	}
+	if n < 2 {
+		n = 2
+	}
+	sort.Float64s(values)
+	q := make(Quantiles, n)
+	for i := range q {
+		q[i] = values[i*(len(values)-1)/(n-1)]
+	}
+	return q
+}
+
+// Sample draws a value from the distribution by interpolating between the
+// quantiles.
+func (q Quantiles) Sample(r *rand.Rand) float64 {
+	if len(q) == 1 {
+		return q[0]
+	}
+	u := r.Float64() * float64(len(q)-1)
+	i := int(u)
+	return q[i] + (q[i+1]-q[i])*(u-float64(i))
+}
+
+// Model is a statistical description of traffic that does not contain any
+// addresses, so it can be shared when the capture it was fitted to cannot.
+// Flows arrive as a Poisson process with FlowRate; the number of packets
+// of a flow, the gaps between its packets and the packet sizes are drawn
+// from empirical distributions. The destination of a flow is drawn by
+// rank from Destinations.
+type Model struct {
+	Duration time.Duration
+	Packets  int64
+	Bytes    int64
+
+	FlowRate float64 // flows per second
+	TCP      float64 // the fraction of TCP flows, the others are UDP
+	IPv6     float64 // the fraction of IPv6 flows
+
+	Sizes       Quantiles // frame sizes in bytes
+	FlowPackets Quantiles // packets per flow
+	Gaps        Quantiles // inter-arrival times within flows in nanoseconds
+
+	// Destinations are the relative numbers of flows to the most popular
+	// destination, to the second most popular destination and so on.
+	Destinations []float64
+}
+
+// LoadModel reads a model from a file. See ParseModel for the format.
+func LoadModel(filename string) (*Model, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	m, err := ParseModel(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+	return m, nil
+}
+
+func parseFloats(fields []string) ([]float64, error) {
+	var values []float64
+	for _, field := range fields {
+		v, err := strconv.ParseFloat(field, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", field)
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+// ParseModel reads a model as it is written by Model.Write, one field per
+// line:
+//
+//	duration      10s
+//	packets       1234567
+//	bytes         987654321
+//	flow-rate     2500
+//	tcp           0.8
+//	ipv6          0.25
+//	sizes         64 64 66 ... 1518
+//	flow-packets  1 1 2 ... 40000
+//	gaps          1200 5400 ... 980000000
+//	destinations  5120 2200 1300 ...
+func ParseModel(r io.Reader) (*Model, error) {
+	m := &Model{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	lineno := 0
+	for scanner.Scan() {
+		lineno++
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i != -1 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		var err error
+		if fields[0] == "duration" {
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("line %d: duration: expected one value", lineno)
+			}
+			m.Duration, err = time.ParseDuration(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s", lineno, err)
+			}
+			continue
+		}
+		values, err := parseFloats(fields[1:])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", lineno, err)
+		}
+		scalar := func(v *float64) error {
+			if len(values) != 1 {
+				return fmt.Errorf("%s: expected one value", fields[0])
+			}
+			*v = values[0]
+			return nil
+		}
+		switch fields[0] {
+		case "packets", "bytes":
+			var v float64
+			err = scalar(&v)
+			if fields[0] == "packets" {
+				m.Packets = int64(v)
+			} else {
+				m.Bytes = int64(v)
+			}
+		case "flow-rate":
+			err = scalar(&m.FlowRate)
+		case "tcp":
+			err = scalar(&m.TCP)
+		case "ipv6":
+			err = scalar(&m.IPv6)
+		case "sizes":
+			m.Sizes = values
+		case "flow-packets":
+			m.FlowPackets = values
+		case "gaps":
+			m.Gaps = values
+		case "destinations":
+			m.Destinations = values
+		default:
+			err = fmt.Errorf("unknown field %q", fields[0])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", lineno, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if m.FlowRate > 0 && (len(m.Sizes) == 0 || len(m.FlowPackets) == 0 || len(m.Destinations) == 0) {
+		return nil, fmt.Errorf("model has flows, but no sizes, flow-packets or destinations")
+	}
+	return m, nil
+}
+
+func writeFloats(w io.Writer, name string, values []float64) {
+	fmt.Fprintf(w, "%s", name)
+	for _, v := range values {
+		fmt.Fprintf(w, " %s", strconv.FormatFloat(v, 'g', -1, 64))
+	}
+	fmt.Fprintf(w, "\n")
+}
+
+// Write writes the model in the format of ParseModel.
+func (m *Model) Write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "# switchemu traffic model\n")
+	fmt.Fprintf(bw, "duration %s\n", m.Duration)
+	fmt.Fprintf(bw, "packets %d\n", m.Packets)
+	fmt.Fprintf(bw, "bytes %d\n", m.Bytes)
+	fmt.Fprintf(bw, "flow-rate %g\n", m.FlowRate)
+	fmt.Fprintf(bw, "tcp %g\n", m.TCP)
+	fmt.Fprintf(bw, "ipv6 %g\n", m.IPv6)
+	writeFloats(bw, "sizes", m.Sizes)
+	writeFloats(bw, "flow-packets", m.FlowPackets)
+	writeFloats(bw, "gaps", m.Gaps)
+	writeFloats(bw, "destinations", m.Destinations)
+	return bw.Flush()
+}
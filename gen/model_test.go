@@ -0,0 +1,72 @@
+package gen
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/dmage/switchemu/hw"
+)
+
+func TestFitModel(t *testing.T) {
+	g, err := Parse("poisson rate=2G size=64:1,1518:1 src=10.0.0.0/24 dst=10.1.0.0/28 dport=80 duration=50ms proto=tcp", epoch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := &hw.World{}
+	fitter := NewFitter(time.Second, hw.NullHandler{})
+	hw.NewReceiver(w, g, g.LinkType(), 40*1000*1000*1000, fitter)
+	w.Simulate()
+	m := fitter.Model()
+
+	if m.TCP != 1 || m.IPv6 != 0 {
+		t.Errorf("got tcp %g and ipv6 %g, want 1 and 0", m.TCP, m.IPv6)
+	}
+	if m.Sizes[0] != 64 || m.Sizes[len(m.Sizes)-1] != 1518 {
+		t.Errorf("got sizes from %g to %g, want from 64 to 1518", m.Sizes[0], m.Sizes[len(m.Sizes)-1])
+	}
+	if len(m.Destinations) > 16 {
+		t.Errorf("got %d destinations, want at most 16", len(m.Destinations))
+	}
+
+	var buf bytes.Buffer
+	if err := m.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParseModel(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parsed, m) {
+		t.Errorf("got %+v after writing and parsing, want %+v", parsed, m)
+	}
+
+	src, _ := ParseAddressPool("10.0.0.0/24")
+	dst, _ := ParseAddressPool("10.1.0.0/24")
+	src6, _ := ParseAddressPool("2001:db8::/64")
+	dst6, _ := ParseAddressPool("2001:db8:1::/64")
+	mg := NewModelGenerator(m, src, dst, src6, dst6, 1)
+	_, n := readAll(t, mg)
+	if math.Abs(float64(n-m.Bytes))/float64(m.Bytes) > 0.1 {
+		t.Errorf("got %d bytes from the model, want about %d", n, m.Bytes)
+	}
+}
+
+func TestFitQuantiles(t *testing.T) {
+	values := []float64{5, 1, 3, 2, 4}
+	for _, tc := range []struct {
+		n    int
+		want Quantiles
+	}{
+		{3, Quantiles{1, 3, 5}},
+		{2, Quantiles{1, 5}},
+		{1, Quantiles{1, 5}},
+		{0, Quantiles{1, 5}},
+	} {
+		if got := fitQuantiles(values, tc.n); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%d quantiles: got %v, want %v", tc.n, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package gen
+
+import (
+	"container/heap"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// ModelGenerator emits traffic that follows a Model. Flows arrive Load
+// times as often as in the model. The destination of rank i is the i-th
+// address of Dst or Dst6, sources are drawn from Src or Src6.
+//
+// The generator starts without active flows, flows that would last longer
+// than Duration are cut off. Models of captures that are not much longer
+// than their flows therefore produce less traffic than the capture had.
+//
+// The generator stops after Duration or after Count packets, whatever
+// comes first; zero means no limit.
+type ModelGenerator struct {
+	Model *Model
+	Load  float64
+
+	Src      *AddressPool
+	Dst      *AddressPool
+	Src6     *AddressPool
+	Dst6     *AddressPool
+	SrcPorts PortRange
+	DstPorts PortRange
+
+	Start    time.Time
+	Duration time.Duration
+	Count    int
+
+	rand     *rand.Rand
+	ranks    *DiscreteSize
+	flows    flowQueue
+	nextFlow time.Duration
+	started  bool
+	n        int
+	builder  builder
+}
+
+type modelFlow struct {
+	flow     flow
+	protocol layers.IPProtocol
+	next     time.Duration
+	left     int
+}
+
+// flowQueue is a heap of flows ordered by the time of their next packet.
+type flowQueue []*modelFlow
+
+func (q flowQueue) Len() int            { return len(q) }
+func (q flowQueue) Less(i, j int) bool  { return q[i].next < q[j].next }
+func (q flowQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *flowQueue) Push(x interface{}) { *q = append(*q, x.(*modelFlow)) }
+func (q *flowQueue) Pop() interface{} {
+	old := *q
+	f := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return f
+}
+
+func NewModelGenerator(m *Model, src, dst, src6, dst6 *AddressPool, seed int64) *ModelGenerator {
+	g := &ModelGenerator{
+		Model:    m,
+		Load:     1,
+		Src:      src,
+		Dst:      dst,
+		Src6:     src6,
+		Dst6:     dst6,
+		SrcPorts: PortRange{Lo: 1024, Hi: 65535},
+		DstPorts: PortRange{Lo: 1024, Hi: 65535},
+		Duration: m.Duration,
+		rand:     rand.New(rand.NewSource(seed)),
+	}
+	if len(m.Destinations) != 0 {
+		ranks := make([]int, len(m.Destinations))
+		for i := range ranks {
+			ranks[i] = i
+		}
+		g.ranks = NewDiscreteSize(ranks, m.Destinations)
+	}
+	return g
+}
+
+func (g *ModelGenerator) LinkType() layers.LinkType {
+	return layers.LinkTypeEthernet
+}
+
+func (g *ModelGenerator) flowGap() time.Duration {
+	return expDuration(g.rand, float64(time.Second)/(g.Model.FlowRate*g.Load))
+}
+
+// startFlow adds a flow that starts at the time t.
+func (g *ModelGenerator) startFlow(t time.Duration) {
+	src, dst := g.Src, g.Dst
+	if g.rand.Float64() < g.Model.IPv6 {
+		src, dst = g.Src6, g.Dst6
+	}
+	protocol := layers.IPProtocolUDP
+	if g.rand.Float64() < g.Model.TCP {
+		protocol = layers.IPProtocolTCP
+	}
+	packets := int(math.Floor(g.Model.FlowPackets.Sample(g.rand) + 0.5))
+	if packets < 1 {
+		packets = 1
+	}
+	heap.Push(&g.flows, &modelFlow{
+		flow: flow{
+			src:     src.Pick(g.rand),
+			dst:     dst.Nth(g.ranks.Size(g.rand)),
+			srcPort: g.SrcPorts.pick(g.rand),
+			dstPort: g.DstPorts.pick(g.rand),
+		},
+		protocol: protocol,
+		next:     t,
+		left:     packets,
+	})
+}
+
+// ReadPacketData returns the next packet. The returned data is valid until
+// the next call.
+func (g *ModelGenerator) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	if g.Model.FlowRate <= 0 || g.Load <= 0 || (g.Count > 0 && g.n >= g.Count) {
+		return nil, gopacket.CaptureInfo{}, io.EOF
+	}
+	if !g.started {
+		g.started = true
+		g.nextFlow = g.flowGap()
+	}
+	for g.flows.Len() == 0 || g.nextFlow <= g.flows[0].next {
+		if g.Duration > 0 && g.nextFlow >= g.Duration {
+			break
+		}
+		g.startFlow(g.nextFlow)
+		g.nextFlow += g.flowGap()
+	}
+	if g.flows.Len() == 0 || (g.Duration > 0 && g.flows[0].next >= g.Duration) {
+		return nil, gopacket.CaptureInfo{}, io.EOF
+	}
+
+	f := g.flows[0]
+	t := f.next
+	size := int(math.Floor(g.Model.Sizes.Sample(g.rand) + 0.5))
+	f.left--
+	if f.left > 0 && len(g.Model.Gaps) != 0 {
+		f.next += time.Duration(g.Model.Gaps.Sample(g.rand))
+		heap.Fix(&g.flows, 0)
+	} else {
+		heap.Pop(&g.flows)
+	}
+
+	data, err := g.builder.build(f.flow, f.protocol, size)
+	if err != nil {
+		return nil, gopacket.CaptureInfo{}, err
+	}
+	g.n++
+	return data, gopacket.CaptureInfo{
+		Timestamp:     g.Start.Add(t),
+		CaptureLength: len(data),
+		Length:        len(data),
+	}, nil
+}
+
+func parseModelGenerator(options map[string]string) (*ModelGenerator, error) {
+	if options["file"] == "" {
+		return nil, fmt.Errorf("file is required")
+	}
+	m, err := LoadModel(options["file"])
+	if err != nil {
+		return nil, err
+	}
+	var pools [4]*AddressPool
+	for i, name := range []string{"src", "dst", "src6", "dst6"} {
+		pools[i], err = ParseAddressPool(options[name])
+		if err != nil {
+			return nil, err
+		}
+		if pools[i].IPv6() != (i >= 2) {
+			return nil, fmt.Errorf("%s: wrong address family", name)
+		}
+	}
+	seed, err := strconv.ParseInt(options["seed"], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid seed %q", options["seed"])
+	}
+
+	g := NewModelGenerator(m, pools[0], pools[1], pools[2], pools[3], seed)
+	if g.Load, err = strconv.ParseFloat(options["load"], 64); err != nil || g.Load <= 0 {
+		return nil, fmt.Errorf("load must be a positive number")
+	}
+	if v := options["sport"]; v != "" {
+		if g.SrcPorts, err = parsePortRange(v); err != nil {
+			return nil, err
+		}
+	}
+	if v := options["dport"]; v != "" {
+		if g.DstPorts, err = parsePortRange(v); err != nil {
+			return nil, err
+		}
+	}
+	if err := parseLimits(options, &g.Start, &g.Duration, &g.Count); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
@@ -0,0 +1,118 @@
+package gen
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Process generates the inter-arrival times of packets.
+type Process interface {
+	// Next returns the time between the previous packet, which has size
+	// bytes, and the next one.
+	Next(r *rand.Rand, size int) time.Duration
+}
+
+// bitsDuration returns the time that size bytes take at rate bits per
+// second.
+func bitsDuration(size int, rate int64) time.Duration {
+	return time.Duration(int64(size) * 8 * int64(time.Second) / rate)
+}
+
+// expDuration returns an exponentially distributed duration with the given
+// mean.
+func expDuration(r *rand.Rand, mean float64) time.Duration {
+	return time.Duration(r.ExpFloat64() * mean)
+}
+
+// pareto returns a Pareto distributed duration with the given mean and
+// shape. The shape must be greater than 1.
+func pareto(r *rand.Rand, mean time.Duration, shape float64) time.Duration {
+	scale := float64(mean) * (shape - 1) / shape
+	return time.Duration(scale / math.Pow(1-r.Float64(), 1/shape))
+}
+
+// CBR sends packets back to back at a constant bit rate.
+type CBR struct {
+	Rate int64 // bits per second
+}
+
+func (p *CBR) Next(r *rand.Rand, size int) time.Duration {
+	return bitsDuration(size, p.Rate)
+}
+
+// Poisson sends packets with exponentially distributed inter-arrival times.
+type Poisson struct {
+	Rate float64 // packets per second
+}
+
+func (p *Poisson) Next(r *rand.Rand, size int) time.Duration {
+	return expDuration(r, float64(time.Second)/p.Rate)
+}
+
+// OnOff alternates between on periods, in which packets are sent at the
+// peak rate, and off periods, in which nothing is sent. The lengths of the
+// periods are Pareto distributed, which makes the aggregate of several
+// sources self-similar.
+type OnOff struct {
+	Rate  int64 // peak bits per second
+	On    time.Duration
+	Off   time.Duration
+	Shape float64
+
+	remaining time.Duration
+	started   bool
+}
+
+func (p *OnOff) Next(r *rand.Rand, size int) time.Duration {
+	if !p.started {
+		p.started = true
+		p.remaining = pareto(r, p.On, p.Shape)
+	}
+	gap := bitsDuration(size, p.Rate)
+	if gap <= p.remaining {
+		p.remaining -= gap
+		return gap
+	}
+	// The on period is over, the next packet starts the next one.
+	gap = p.remaining + pareto(r, p.Off, p.Shape)
+	p.remaining = pareto(r, p.On, p.Shape)
+	return gap
+}
+
+// MMPP is a Markov-modulated Poisson process. In every state packets
+// arrive as a Poisson process with the rate of the state. The process
+// stays in a state for an exponentially distributed time with the mean
+// dwell time of the state and then moves on to the next state, after the
+// last state it returns to the first one. At least one state must have a
+// positive rate, otherwise Next never returns.
+type MMPP struct {
+	Rates []float64 // packets per second
+	Dwell []time.Duration
+
+	state     int
+	remaining time.Duration
+	started   bool
+}
+
+func (p *MMPP) Next(r *rand.Rand, size int) time.Duration {
+	if !p.started {
+		p.started = true
+		p.remaining = expDuration(r, float64(p.Dwell[p.state]))
+	}
+	var t time.Duration
+	for {
+		if rate := p.Rates[p.state]; rate > 0 {
+			// Arrivals are memoryless, so the time to the next packet can
+			// be drawn again after a state change.
+			gap := expDuration(r, float64(time.Second)/rate)
+			if gap < p.remaining {
+				p.remaining -= gap
+				return t + gap
+			}
+		}
+		t += p.remaining
+		p.state = (p.state + 1) % len(p.Rates)
+		p.remaining = expDuration(r, float64(p.Dwell[p.state]))
+	}
+}
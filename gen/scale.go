@@ -0,0 +1,209 @@
+package gen
+
+import (
+	"container/heap"
+	"encoding/binary"
+	"math/rand"
+	"time"
+
+	"github.com/dmage/switchemu/hw"
+	"github.com/google/gopacket"
+)
+
+// Compress is a packet data source that divides the time between Origin
+// and every packet of Source by Factor. A factor of 2 replays a capture
+// in half the time, i.e. at twice the load.
+//
+// Compression does not shorten the packets themselves; the Receiver still
+// delays packets that would arrive faster than its input bandwidth.
+type Compress struct {
+	Source gopacket.PacketDataSource
+	Factor float64
+	Origin time.Time
+}
+
+func NewCompress(source gopacket.PacketDataSource, factor float64, origin time.Time) *Compress {
+	return &Compress{
+		Source: source,
+		Factor: factor,
+		Origin: origin,
+	}
+}
+
+func (c *Compress) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	data, ci, err := c.Source.ReadPacketData()
+	if err == nil {
+		ci.Timestamp = c.Origin.Add(time.Duration(float64(ci.Timestamp.Sub(c.Origin)) / c.Factor))
+	}
+	return data, ci, err
+}
+
+// Multiply is a packet data source that replays every flow of Source
+// Copies times. The first copy is the original flow. The other copies get
+// new source addresses, k is added to the second byte of IPv4 addresses
+// and to the third 16-bit group of IPv6 addresses of copy k, and are
+// delayed by a random offset of less than MaxOffset, which is drawn once
+// per flow and copy. Checksums are updated. Packets that are not IPv4 or
+// IPv6 packets are not multiplied.
+type Multiply struct {
+	Source    gopacket.PacketDataSource
+	Copies    int
+	MaxOffset time.Duration
+
+	rand    *rand.Rand
+	offsets map[string][]time.Duration
+	queue   packetQueue
+	seq     int64
+	last    time.Time
+	err     error
+	headers hw.Headers
+	key     []byte
+}
+
+func NewMultiply(source gopacket.PacketDataSource, copies int, maxOffset time.Duration, seed int64) *Multiply {
+	return &Multiply{
+		Source:    source,
+		Copies:    copies,
+		MaxOffset: maxOffset,
+		rand:      rand.New(rand.NewSource(seed)),
+		offsets:   make(map[string][]time.Duration),
+	}
+}
+
+type queuedPacket struct {
+	data []byte
+	ci   gopacket.CaptureInfo
+	seq  int64
+}
+
+// packetQueue is a heap of packets ordered by their timestamps.
+type packetQueue []queuedPacket
+
+func (q packetQueue) Len() int { return len(q) }
+func (q packetQueue) Less(i, j int) bool {
+	if q[i].ci.Timestamp.Equal(q[j].ci.Timestamp) {
+		return q[i].seq < q[j].seq
+	}
+	return q[i].ci.Timestamp.Before(q[j].ci.Timestamp)
+}
+func (q packetQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *packetQueue) Push(x interface{}) { *q = append(*q, x.(queuedPacket)) }
+func (q *packetQueue) Pop() interface{} {
+	old := *q
+	p := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return p
+}
+
+func (m *Multiply) push(data []byte, ci gopacket.CaptureInfo) {
+	m.seq++
+	heap.Push(&m.queue, queuedPacket{data: data, ci: ci, seq: m.seq})
+}
+
+// ReadPacketData returns the next packet. Copies of a packet are held back
+// until no earlier packet can follow.
+func (m *Multiply) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	for {
+		if m.queue.Len() != 0 && (m.err != nil || !m.queue[0].ci.Timestamp.After(m.last)) {
+			p := heap.Pop(&m.queue).(queuedPacket)
+			return p.data, p.ci, nil
+		}
+		if m.err != nil {
+			return nil, gopacket.CaptureInfo{}, m.err
+		}
+
+		data, ci, err := m.Source.ReadPacketData()
+		if err != nil {
+			m.err = err
+			continue
+		}
+		m.last = ci.Timestamp
+		m.push(append([]byte(nil), data...), ci)
+		if m.Copies <= 1 || !m.headers.Parse(data) {
+			continue
+		}
+
+		h := &m.headers
+		key := append(m.key[:0], h.SrcIP...)
+		key = append(key, h.DstIP...)
+		key = append(key, h.Protocol, byte(h.SrcPort>>8), byte(h.SrcPort), byte(h.DstPort>>8), byte(h.DstPort))
+		m.key = key
+		offsets, ok := m.offsets[string(key)]
+		if !ok {
+			offsets = make([]time.Duration, m.Copies)
+			for k := 1; k < m.Copies; k++ {
+				if m.MaxOffset > 0 {
+					offsets[k] = time.Duration(m.rand.Int63n(int64(m.MaxOffset)))
+				}
+			}
+			m.offsets[string(key)] = offsets
+		}
+
+		for k := 1; k < m.Copies; k++ {
+			c := append([]byte(nil), data...)
+			rewriteSource(c, h, k)
+			copyCI := ci
+			copyCI.Timestamp = ci.Timestamp.Add(offsets[k])
+			m.push(c, copyCI)
+		}
+	}
+}
+
+// updateChecksum updates an Internet checksum after a 16-bit word of the
+// checksummed data has changed from old to word (RFC 1624).
+func updateChecksum(sum, old, word uint16) uint16 {
+	s := uint32(^sum) + uint32(^old) + uint32(word)
+	s = s&0xFFFF + s>>16
+	s = s&0xFFFF + s>>16
+	return ^uint16(s)
+}
+
+// rewriteSource adds k to a 16-bit word of the source address of the
+// packet data with the parsed headers h and updates the checksums that
+// cover it.
+func rewriteSource(data []byte, h *hw.Headers, k int) {
+	var offset int
+	switch h.EtherType {
+	case hw.EtherTypeIPv4:
+		offset = h.L3Offset + 12
+	case hw.EtherTypeIPv6:
+		offset = h.L3Offset + 8 + 4
+	default:
+		return
+	}
+	old := binary.BigEndian.Uint16(data[offset:])
+	var word uint16
+	if h.EtherType == hw.EtherTypeIPv4 {
+		word = old&0xFF00 | uint16(byte(old)+byte(k))
+		sum := binary.BigEndian.Uint16(data[h.L3Offset+10:])
+		binary.BigEndian.PutUint16(data[h.L3Offset+10:], updateChecksum(sum, old, word))
+	} else {
+		word = old + uint16(k)
+	}
+	binary.BigEndian.PutUint16(data[offset:], word)
+
+	if h.L4Offset == 0 {
+		return
+	}
+	var sumOffset int
+	switch h.Protocol {
+	case hw.ProtocolTCP:
+		sumOffset = h.L4Offset + 16
+	case hw.ProtocolUDP:
+		sumOffset = h.L4Offset + 6
+	default:
+		return
+	}
+	if len(data) < sumOffset+2 {
+		return
+	}
+	sum := binary.BigEndian.Uint16(data[sumOffset:])
+	if h.Protocol == hw.ProtocolUDP && sum == 0 {
+		return // no checksum
+	}
+	sum = updateChecksum(sum, old, word)
+	if h.Protocol == hw.ProtocolUDP && sum == 0 {
+		sum = 0xFFFF
+	}
+	binary.BigEndian.PutUint16(data[sumOffset:], sum)
+}
@@ -0,0 +1,82 @@
+package gen
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/dmage/switchemu/hw"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+func TestCompress(t *testing.T) {
+	g, _ := Parse("cbr rate=1G size=1250 count=10", epoch)
+	c := NewCompress(g, 4, epoch)
+	timestamps, _ := readAll(t, c)
+	if d := timestamps[9].Sub(timestamps[0]); d != 9*10*time.Microsecond/4 {
+		t.Errorf("got %s between the first and the last packet, want 22.5us", d)
+	}
+}
+
+// reserialize decodes the packet data and serializes it again with fresh
+// checksums.
+func reserialize(t *testing.T, data []byte) []byte {
+	packet := gopacket.NewPacket(data, layers.LayerTypeEthernet, gopacket.Default)
+	var serializable []gopacket.SerializableLayer
+	for _, l := range packet.Layers() {
+		switch l := l.(type) {
+		case *layers.TCP:
+			l.SetNetworkLayerForChecksum(packet.NetworkLayer())
+		case *layers.UDP:
+			l.SetNetworkLayerForChecksum(packet.NetworkLayer())
+		}
+		serializable = append(serializable, l.(gopacket.SerializableLayer))
+	}
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{ComputeChecksums: true}, serializable...); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestMultiply(t *testing.T) {
+	for _, spec := range []string{
+		"poisson rate=1G size=64-1518 src=10.0.0.0/24 dst=10.1.0.1 flows=5 count=200",
+		"poisson rate=1G size=64-1518 src=2001:db8::/64 dst=2001:db8:1::1 proto=tcp flows=5 count=200",
+	} {
+		g, _ := Parse(spec, epoch)
+		m := NewMultiply(g, 3, 50*time.Microsecond, 1)
+
+		sources := make(map[string]int)
+		var prev time.Time
+		n := 0
+		for {
+			data, ci, err := m.ReadPacketData()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ci.Timestamp.Before(prev) {
+				t.Fatalf("%s: packet %d: timestamps are not ordered", spec, n)
+			}
+			prev = ci.Timestamp
+			if !bytes.Equal(data, reserialize(t, data)) {
+				t.Fatalf("%s: packet %d: wrong checksums", spec, n)
+			}
+			var h hw.Headers
+			h.Parse(data)
+			sources[h.SrcIP.String()]++
+			n++
+		}
+		if n != 3*200 {
+			t.Errorf("%s: got %d packets, want 600", spec, n)
+		}
+		if len(sources) > 3*5 || len(sources) < 3 {
+			t.Errorf("%s: got %d source addresses, want up to 15", spec, len(sources))
+		}
+	}
+}
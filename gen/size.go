@@ -0,0 +1,145 @@
+package gen
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// SizeDist is a distribution of frame sizes in bytes.
+type SizeDist interface {
+	Size(r *rand.Rand) int
+	Mean() float64
+}
+
+// FixedSize always returns the same size.
+type FixedSize int
+
+func (s FixedSize) Size(r *rand.Rand) int {
+	return int(s)
+}
+
+func (s FixedSize) Mean() float64 {
+	return float64(s)
+}
+
+// UniformSize returns sizes between Min and Max inclusive.
+type UniformSize struct {
+	Min int
+	Max int
+}
+
+func (s UniformSize) Size(r *rand.Rand) int {
+	return s.Min + r.Intn(s.Max-s.Min+1)
+}
+
+func (s UniformSize) Mean() float64 {
+	return float64(s.Min+s.Max) / 2
+}
+
+// DiscreteSize returns one of Sizes with probabilities proportional to
+// Weights.
+type DiscreteSize struct {
+	Sizes   []int
+	Weights []float64
+
+	total float64
+}
+
+func NewDiscreteSize(sizes []int, weights []float64) *DiscreteSize {
+	s := &DiscreteSize{
+		Sizes:   sizes,
+		Weights: weights,
+	}
+	for _, w := range weights {
+		s.total += w
+	}
+	return s
+}
+
+func (s *DiscreteSize) Size(r *rand.Rand) int {
+	x := r.Float64() * s.total
+	for i, w := range s.Weights {
+		if x < w {
+			return s.Sizes[i]
+		}
+		x -= w
+	}
+	return s.Sizes[len(s.Sizes)-1]
+}
+
+func (s *DiscreteSize) Mean() float64 {
+	var sum float64
+	for i, w := range s.Weights {
+		sum += float64(s.Sizes[i]) * w
+	}
+	return sum / s.total
+}
+
+// imix is the simple IMIX: 7 parts 64 bytes, 4 parts 594 bytes and 1 part
+// 1518 bytes.
+const imix = "64:7,594:4,1518:1"
+
+// ParseSizeDist parses a size distribution: a fixed size ("1500"), a
+// uniform range ("64-1518"), a weighted list of sizes ("64:7,594:4,1518:1")
+// or "imix".
+func ParseSizeDist(s string) (SizeDist, error) {
+	if s == "imix" {
+		s = imix
+	}
+	parseSize := func(v string) (int, error) {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("invalid size %q", v)
+		}
+		return n, nil
+	}
+
+	if strings.ContainsAny(s, ":,") {
+		var sizes []int
+		var weights []float64
+		for _, item := range strings.Split(s, ",") {
+			parts := strings.SplitN(item, ":", 2)
+			size, err := parseSize(parts[0])
+			if err != nil {
+				return nil, err
+			}
+			weight := 1.0
+			if len(parts) == 2 {
+				weight, err = strconv.ParseFloat(parts[1], 64)
+				if err != nil || weight < 0 {
+					return nil, fmt.Errorf("invalid weight %q", parts[1])
+				}
+			}
+			sizes = append(sizes, size)
+			weights = append(weights, weight)
+		}
+		d := NewDiscreteSize(sizes, weights)
+		if d.total <= 0 {
+			return nil, fmt.Errorf("size distribution %q has no weight", s)
+		}
+		return d, nil
+	}
+
+	if i := strings.IndexByte(s, '-'); i != -1 {
+		min, err := parseSize(s[:i])
+		if err != nil {
+			return nil, err
+		}
+		max, err := parseSize(s[i+1:])
+		if err != nil {
+			return nil, err
+		}
+		if max < min {
+			return nil, fmt.Errorf("invalid size range %q", s)
+		}
+		return UniformSize{Min: min, Max: max}, nil
+	}
+
+	size, err := parseSize(s)
+	if err != nil {
+		return nil, err
+	}
+	return FixedSize(size), nil
+}
@@ -0,0 +1,278 @@
+package gen
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dmage/switchemu/hw"
+	"github.com/google/gopacket/layers"
+)
+
+func parsePortRange(s string) (PortRange, error) {
+	lo, hi := s, s
+	if i := strings.IndexByte(s, '-'); i != -1 {
+		lo, hi = s[:i], s[i+1:]
+	}
+	l, err := strconv.ParseUint(lo, 10, 16)
+	if err != nil {
+		return PortRange{}, fmt.Errorf("invalid port range %q", s)
+	}
+	h, err := strconv.ParseUint(hi, 10, 16)
+	if err != nil || h < l {
+		return PortRange{}, fmt.Errorf("invalid port range %q", s)
+	}
+	return PortRange{Lo: uint16(l), Hi: uint16(h)}, nil
+}
+
+func parseDurations(s string) ([]time.Duration, error) {
+	var ds []time.Duration
+	for _, item := range strings.Split(s, ",") {
+		d, err := time.ParseDuration(item)
+		if err != nil {
+			return nil, err
+		}
+		if d <= 0 {
+			return nil, fmt.Errorf("duration %q must be positive", item)
+		}
+		ds = append(ds, d)
+	}
+	return ds, nil
+}
+
+// common are the options of all generators with their defaults. An empty
+// default means that the option is not set.
+var common = map[string]string{
+	"src":      "10.0.0.0/24",
+	"sport":    "",
+	"dport":    "",
+	"duration": "",
+	"count":    "",
+	"start":    "",
+	"seed":     "1",
+}
+
+// kinds are the options of every kind of generator with their defaults.
+var kinds = map[string]map[string]string{
+	"cbr":     {"rate": "1G", "size": "1500", "dst": "10.1.0.0/24", "proto": "udp", "flows": "0"},
+	"poisson": {"rate": "1G", "size": "1500", "dst": "10.1.0.0/24", "proto": "udp", "flows": "0"},
+	"onoff":   {"rate": "1G", "size": "1500", "dst": "10.1.0.0/24", "proto": "udp", "flows": "0", "on": "1ms", "off": "1ms", "shape": "1.5"},
+	"mmpp":    {"size": "1500", "dst": "10.1.0.0/24", "proto": "udp", "flows": "0", "rates": "", "dwell": ""},
+	"incast":  {"senders": "16", "burst": "64k", "size": "1500", "rate": "10G", "dst": "10.1.0.1", "proto": "udp", "interval": "1ms", "jitter": "0"},
+	"model":   {"file": "", "load": "1", "dst": "10.1.0.0/16", "src6": "2001:db8::/64", "dst6": "2001:db8:1::/64"},
+}
+
+// Parse returns a generator for a spec of the form "KIND OPTION=VALUE...":
+//
+//	cbr     rate=10G size=1500 dst=10.1.0.1
+//	poisson rate=4G size=imix flows=1000 duration=100ms seed=2
+//	onoff   rate=10G on=1ms off=4ms shape=1.5
+//	mmpp    rates=1G,8G dwell=10ms,1ms
+//	incast  senders=32 burst=64k rate=10G interval=1ms jitter=20us
+//	model   file=traffic.model load=2
+//
+// rate is the bit rate of cbr, the mean bit rate of poisson and the peak
+// bit rate of onoff. on and off are the mean lengths of the Pareto
+// distributed on and off periods, shape is their shape parameter. rates
+// and dwell are the mean bit rates and the mean dwell times of the states
+// of mmpp. Bit rates are converted to packet rates by the mean frame size.
+// See Incast for the options of incast, its count is a number of bursts.
+// model generates traffic from a model file (see ModelGenerator and
+// ParseModel), it has the options src, dst, src6 and dst6 for the IPv4 and
+// IPv6 addresses and lasts as long as the fitted capture by default.
+//
+// The common options are size (see ParseSizeDist), src and dst (see
+// ParseAddressPool), proto (udp or tcp), sport and dport (PORT or
+// PORT-PORT), flows, duration, count, start (an RFC 3339 time) and seed.
+// The defaults are rate=1G size=1500 src=10.0.0.0/24 dst=10.1.0.0/24
+// duration=1s seed=1, a generator with a count has no default duration.
+// incast defaults to senders=16 burst=64k rate=10G interval=1ms
+// dst=10.1.0.1. Without the start option the first packet is sent at
+// start.
+func Parse(spec string, start time.Time) (Source, error) {
+	fields := strings.Fields(spec)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("empty generator spec")
+	}
+	kind := fields[0]
+	defaults, ok := kinds[kind]
+	if !ok {
+		return nil, fmt.Errorf("unknown generator %q", kind)
+	}
+
+	options := make(map[string]string)
+	for _, m := range []map[string]string{common, defaults} {
+		for key, value := range m {
+			options[key] = value
+		}
+	}
+	for _, arg := range fields[1:] {
+		kv := strings.SplitN(arg, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("%s: invalid option %q", kind, arg)
+		}
+		if _, ok := options[kv[0]]; !ok {
+			return nil, fmt.Errorf("%s: unknown option %q", kind, kv[0])
+		}
+		options[kv[0]] = kv[1]
+	}
+	if options["duration"] == "" && options["count"] == "" && kind != "model" {
+		options["duration"] = "1s"
+	}
+	if options["start"] == "" {
+		options["start"] = start.Format(time.RFC3339Nano)
+	}
+
+	var source Source
+	var err error
+	switch kind {
+	case "incast":
+		source, err = parseIncast(options)
+	case "model":
+		source, err = parseModelGenerator(options)
+	default:
+		source, err = parseGenerator(kind, options)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", kind, err)
+	}
+	return source, nil
+}
+
+// parseFlow parses the options that describe packets and their flows.
+func parseFlow(options map[string]string, src, dst *AddressPool, protocol *layers.IPProtocol, srcPorts, dstPorts *PortRange) error {
+	if src.IPv6() != dst.IPv6() {
+		return fmt.Errorf("source and destination addresses are of different families")
+	}
+	switch options["proto"] {
+	case "udp":
+		*protocol = layers.IPProtocolUDP
+	case "tcp":
+		*protocol = layers.IPProtocolTCP
+	default:
+		return fmt.Errorf("unknown protocol %q", options["proto"])
+	}
+	var err error
+	if v := options["sport"]; v != "" {
+		if *srcPorts, err = parsePortRange(v); err != nil {
+			return err
+		}
+	}
+	if v := options["dport"]; v != "" {
+		if *dstPorts, err = parsePortRange(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseLimits parses the start time, the duration and the count.
+func parseLimits(options map[string]string, start *time.Time, duration *time.Duration, count *int) error {
+	var err error
+	if *start, err = time.Parse(time.RFC3339Nano, options["start"]); err != nil {
+		return err
+	}
+	if v := options["duration"]; v != "" {
+		if *duration, err = time.ParseDuration(v); err != nil {
+			return err
+		}
+	}
+	if v := options["count"]; v != "" {
+		if *count, err = strconv.Atoi(v); err != nil {
+			return fmt.Errorf("invalid count %q", v)
+		}
+	}
+	return nil
+}
+
+func parseGenerator(kind string, options map[string]string) (*Generator, error) {
+	sizes, err := ParseSizeDist(options["size"])
+	if err != nil {
+		return nil, err
+	}
+	src, err := ParseAddressPool(options["src"])
+	if err != nil {
+		return nil, err
+	}
+	dst, err := ParseAddressPool(options["dst"])
+	if err != nil {
+		return nil, err
+	}
+	seed, err := strconv.ParseInt(options["seed"], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid seed %q", options["seed"])
+	}
+	packetRate := func(rate int64) float64 {
+		return float64(rate) / (8 * sizes.Mean())
+	}
+
+	var rate int64
+	if kind != "mmpp" {
+		if rate, err = hw.ParseBandwidth(options["rate"]); err != nil {
+			return nil, err
+		}
+	}
+	var process Process
+	switch kind {
+	case "cbr":
+		process = &CBR{Rate: rate}
+	case "poisson":
+		process = &Poisson{Rate: packetRate(rate)}
+	case "onoff":
+		p := &OnOff{Rate: rate}
+		if p.On, err = time.ParseDuration(options["on"]); err != nil {
+			return nil, err
+		}
+		if p.Off, err = time.ParseDuration(options["off"]); err != nil {
+			return nil, err
+		}
+		if p.Shape, err = strconv.ParseFloat(options["shape"], 64); err != nil || p.Shape <= 1 {
+			return nil, fmt.Errorf("shape must be a number greater than 1")
+		}
+		if p.On <= 0 || p.Off < 0 {
+			return nil, fmt.Errorf("invalid on or off period")
+		}
+		process = p
+	case "mmpp":
+		if options["rates"] == "" || options["dwell"] == "" {
+			return nil, fmt.Errorf("rates and dwell are required")
+		}
+		p := &MMPP{}
+		active := false
+		for _, item := range strings.Split(options["rates"], ",") {
+			var r int64
+			if item != "0" {
+				r, err = hw.ParseBandwidth(item)
+				if err != nil {
+					return nil, err
+				}
+				active = true
+			}
+			p.Rates = append(p.Rates, packetRate(r))
+		}
+		if !active {
+			// The process would never send a packet.
+			return nil, fmt.Errorf("at least one rate must be positive")
+		}
+		if p.Dwell, err = parseDurations(options["dwell"]); err != nil {
+			return nil, err
+		}
+		if len(p.Rates) != len(p.Dwell) {
+			return nil, fmt.Errorf("rates and dwell must have the same number of states")
+		}
+		process = p
+	}
+
+	g := NewGenerator(process, sizes, src, dst, seed)
+	if g.Flows, err = strconv.Atoi(options["flows"]); err != nil {
+		return nil, fmt.Errorf("invalid number of flows %q", options["flows"])
+	}
+	if err := parseFlow(options, g.Src, g.Dst, &g.Protocol, &g.SrcPorts, &g.DstPorts); err != nil {
+		return nil, err
+	}
+	if err := parseLimits(options, &g.Start, &g.Duration, &g.Count); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
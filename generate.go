@@ -0,0 +1,70 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/dmage/switchemu/gen"
+	"github.com/dmage/switchemu/hw"
+)
+
+// genCommand writes the traffic of generators to a capture file, so that
+// it can be replayed later as an input file or by other tools.
+func genCommand(args []string) {
+	fs := flag.NewFlagSet("gen", flag.ExitOnError)
+	output := fs.String("o", "traffic.pcap", "write the packets to `file`, files with the .pcapng extension are written as pcapng")
+	start := fs.String("start", "1970-01-01T00:00:00Z", "start generators without a start option at `time` (RFC 3339)")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s gen [flags] spec...\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "each spec describes a generator like -generate, e.g. \"poisson rate=4G size=imix\"\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	startTime, err := time.Parse(time.RFC3339Nano, *start)
+	if err != nil {
+		log.Fatalf("invalid start time: %s", err)
+	}
+
+	var sources []gen.Source
+	for _, spec := range fs.Args() {
+		g, err := gen.Parse(spec, startTime)
+		if err != nil {
+			log.Fatal(err)
+		}
+		sources = append(sources, g)
+	}
+	source := gen.NewMerge(sources...)
+
+	pw, err := hw.NewPcapWriter(*output, source.LinkType(), hw.NullHandler{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	var packets, bytes int64
+	for {
+		data, ci, err := source.ReadPacketData()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := pw.WritePacket(ci, data); err != nil {
+			log.Fatalf("%s: %s", *output, err)
+		}
+		packets++
+		bytes += int64(ci.Length)
+	}
+	if err := pw.Close(); err != nil {
+		log.Fatalf("%s: %s", *output, err)
+	}
+	log.Printf("generated %d packets, %d bytes, written to %s", packets, bytes, *output)
+}
@@ -0,0 +1,325 @@
+package hw
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+type ACLAction int
+
+const (
+	ACLPermit ACLAction = iota
+	ACLDeny
+	ACLRedirect
+)
+
+func (a ACLAction) String() string {
+	switch a {
+	case ACLPermit:
+		return "permit"
+	case ACLDeny:
+		return "deny"
+	case ACLRedirect:
+		return "redirect"
+	}
+	return fmt.Sprintf("ACLAction(%d)", int(a))
+}
+
+// PortRange is an inclusive range of transport ports.
+type PortRange struct {
+	Lo uint16
+	Hi uint16
+}
+
+func (r PortRange) Contains(port uint16) bool {
+	return port >= r.Lo && port <= r.Hi
+}
+
+// ACLRule is an entry of an access-control list. Nil prefixes and port
+// ranges and negative Protocol, DSCP and VLAN values match any packet.
+type ACLRule struct {
+	Action ACLAction
+	Queue  string // the output of ACLRedirect
+
+	SrcPrefix *net.IPNet
+	DstPrefix *net.IPNet
+	Protocol  int
+	SrcPorts  *PortRange
+	DstPorts  *PortRange
+	DSCP      int
+	VLAN      int
+
+	// Text is the rule as it was written in the ACL file.
+	Text string
+
+	Hits     int64
+	HitBytes int64
+}
+
+func prefixMatch(prefix *net.IPNet, ip net.IP) bool {
+	return prefix == nil || (len(ip) == len(prefix.IP) && prefix.Contains(ip))
+}
+
+// Match reports whether the rule matches a packet with the headers h. ok is
+// false if the headers could not be parsed, such packets only match rules
+// without IP conditions.
+func (r *ACLRule) Match(h *Headers, ok bool) bool {
+	if r.VLAN >= 0 && (!h.HasVLAN || int(h.VLAN) != r.VLAN) {
+		return false
+	}
+	if r.SrcPrefix == nil && r.DstPrefix == nil && r.Protocol < 0 && r.SrcPorts == nil && r.DstPorts == nil && r.DSCP < 0 {
+		return true
+	}
+	if !ok {
+		return false
+	}
+	if !prefixMatch(r.SrcPrefix, h.SrcIP) || !prefixMatch(r.DstPrefix, h.DstIP) {
+		return false
+	}
+	if r.Protocol >= 0 && int(h.Protocol) != r.Protocol {
+		return false
+	}
+	if r.DSCP >= 0 && int(h.DSCP) != r.DSCP {
+		return false
+	}
+	if r.SrcPorts != nil || r.DstPorts != nil {
+		if !h.HasPorts {
+			return false
+		}
+		if r.SrcPorts != nil && !r.SrcPorts.Contains(h.SrcPort) {
+			return false
+		}
+		if r.DstPorts != nil && !r.DstPorts.Contains(h.DstPort) {
+			return false
+		}
+	}
+	return true
+}
+
+// LoadACL reads access-control rules from a file. See ParseACL for the
+// format.
+func LoadACL(filename string) ([]*ACLRule, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	rules, err := ParseACL(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+	return rules, nil
+}
+
+var aclProtocols = map[string]int{
+	"icmp":  ProtocolICMP,
+	"tcp":   ProtocolTCP,
+	"udp":   ProtocolUDP,
+	"gre":   ProtocolGRE,
+	"icmp6": ProtocolICMPv6,
+	"sctp":  ProtocolSCTP,
+}
+
+func parsePortRange(s string) (*PortRange, error) {
+	lo, hi := s, s
+	if i := strings.IndexByte(s, '-'); i != -1 {
+		lo, hi = s[:i], s[i+1:]
+	}
+	l, err := strconv.ParseUint(lo, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port range %q", s)
+	}
+	h, err := strconv.ParseUint(hi, 10, 16)
+	if err != nil || h < l {
+		return nil, fmt.Errorf("invalid port range %q", s)
+	}
+	return &PortRange{Lo: uint16(l), Hi: uint16(h)}, nil
+}
+
+// ParseACL reads an ordered list of access-control rules, one rule per
+// line:
+//
+//	# action        conditions
+//	deny            vlan 200
+//	redirect bulk   dst 2001:db8::/32 proto udp dport 5000-6000
+//	permit          src 10.0.0.0/8 proto tcp dport 443 dscp 46
+//	permit          any
+//
+// The conditions are src PREFIX, dst PREFIX, proto NAME|NUMBER,
+// sport PORT[-PORT], dport PORT[-PORT], dscp N and vlan ID. A rule matches
+// if all of its conditions match, a rule without conditions (or with
+// "any") matches every packet.
+func ParseACL(r io.Reader) ([]*ACLRule, error) {
+	var rules []*ACLRule
+	scanner := bufio.NewScanner(r)
+	lineno := 0
+	for scanner.Scan() {
+		lineno++
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i != -1 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		rule := &ACLRule{
+			Protocol: -1,
+			DSCP:     -1,
+			VLAN:     -1,
+			Text:     strings.Join(fields, " "),
+		}
+		switch fields[0] {
+		case "permit":
+			rule.Action = ACLPermit
+		case "deny":
+			rule.Action = ACLDeny
+		case "redirect":
+			if len(fields) < 2 {
+				return nil, fmt.Errorf("line %d: redirect: missing queue", lineno)
+			}
+			rule.Action = ACLRedirect
+			rule.Queue = fields[1]
+			fields = fields[1:]
+		default:
+			return nil, fmt.Errorf("line %d: unknown action %q", lineno, fields[0])
+		}
+		fields = fields[1:]
+
+		for len(fields) != 0 {
+			if fields[0] == "any" {
+				fields = fields[1:]
+				continue
+			}
+			if len(fields) < 2 {
+				return nil, fmt.Errorf("line %d: %s: missing value", lineno, fields[0])
+			}
+			var err error
+			value := fields[1]
+			switch fields[0] {
+			case "src":
+				_, rule.SrcPrefix, err = net.ParseCIDR(value)
+			case "dst":
+				_, rule.DstPrefix, err = net.ParseCIDR(value)
+			case "proto":
+				var ok bool
+				rule.Protocol, ok = aclProtocols[value]
+				if !ok {
+					var n uint64
+					n, err = strconv.ParseUint(value, 10, 8)
+					if err != nil {
+						err = fmt.Errorf("invalid protocol %q", value)
+					}
+					rule.Protocol = int(n)
+				}
+			case "sport":
+				rule.SrcPorts, err = parsePortRange(value)
+			case "dport":
+				rule.DstPorts, err = parsePortRange(value)
+			case "dscp":
+				var n uint64
+				n, err = strconv.ParseUint(value, 10, 6)
+				if err != nil {
+					err = fmt.Errorf("invalid dscp %q", value)
+				}
+				rule.DSCP = int(n)
+			case "vlan":
+				var n uint64
+				n, err = strconv.ParseUint(value, 10, 12)
+				if err != nil {
+					err = fmt.Errorf("invalid vlan %q", value)
+				}
+				rule.VLAN = int(n)
+			default:
+				return nil, fmt.Errorf("line %d: unknown condition %q", lineno, fields[0])
+			}
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s", lineno, err)
+			}
+			fields = fields[2:]
+		}
+
+		rules = append(rules, rule)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// ACL evaluates access-control rules in order and applies the action of the
+// first rule that matches. Permitted packets are passed to Output, denied
+// packets to Drop, and redirected packets to the output for the rule's
+// queue, which is created on demand by NewOutput. Packets that match no
+// rule are denied.
+//
+// Several ACLs may share the same rules, the hit counters of the rules are
+// then the totals over all of them. If Decapsulate is set, the rules match
+// the inner headers of tunnel packets.
+type ACL struct {
+	Rules       []*ACLRule
+	Output      Handler
+	NewOutput   func(queue string) Handler
+	Drop        Handler
+	Decapsulate bool
+
+	Unmatched      int64
+	UnmatchedBytes int64
+
+	queues  map[string]Handler
+	headers Headers
+}
+
+func NewACL(rules []*ACLRule, output Handler, newOutput func(queue string) Handler, drop Handler) *ACL {
+	return &ACL{
+		Rules:     rules,
+		Output:    output,
+		NewOutput: newOutput,
+		Drop:      drop,
+		queues:    make(map[string]Handler),
+	}
+}
+
+// Lookup returns the first rule that matches the packet, or nil.
+func (acl *ACL) Lookup(p *Packet) *ACLRule {
+	ok := acl.headers.ParseFor(p.CapturedData, acl.Decapsulate)
+	for _, rule := range acl.Rules {
+		if rule.Match(&acl.headers, ok) {
+			return rule
+		}
+	}
+	return nil
+}
+
+func (acl *ACL) HandlePacket(w *World, p *Packet) {
+	rule := acl.Lookup(p)
+	if rule == nil {
+		acl.Unmatched++
+		acl.UnmatchedBytes += int64(p.Length)
+		acl.Drop.HandlePacket(w, p)
+		return
+	}
+
+	rule.Hits++
+	rule.HitBytes += int64(p.Length)
+	switch rule.Action {
+	case ACLPermit:
+		acl.Output.HandlePacket(w, p)
+	case ACLRedirect:
+		h, ok := acl.queues[rule.Queue]
+		if !ok {
+			h = acl.NewOutput(rule.Queue)
+			acl.queues[rule.Queue] = h
+		}
+		h.HandlePacket(w, p)
+	default:
+		acl.Drop.HandlePacket(w, p)
+	}
+}
@@ -0,0 +1,79 @@
+package hw
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestACL(t *testing.T) {
+	rules, err := ParseACL(strings.NewReader(`
+# action        conditions
+deny            proto tcp dport 22
+redirect bulk   dst 192.0.2.0/24 proto tcp dport 5000-6000
+permit          src 2001:db8::/32
+permit          proto tcp dscp 46
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counts := make(map[string]int)
+	counter := func(name string) Handler {
+		return HandlerFunc(func(w *World, p *Packet) {
+			counts[name]++
+		})
+	}
+	acl := NewACL(rules, counter("permit"), counter, counter("drop"))
+
+	tcp := func(src, dst string, dport uint16, dscp uint8) []byte {
+		ip := ipv4Header(net.ParseIP(src), net.ParseIP(dst))
+		ip[1] = dscp << 2
+		ip[9] = ProtocolTCP
+		return ethernetFrame(EtherTypeIPv4, append(ip, 0x04, 0xD2, byte(dport>>8), byte(dport)))
+	}
+	for _, data := range [][]byte{
+		tcp("198.51.100.1", "192.0.2.1", 22, 46),
+		tcp("198.51.100.1", "192.0.2.1", 5201, 0),
+		tcp("198.51.100.1", "203.0.113.1", 5201, 0),
+		tcp("198.51.100.1", "203.0.113.1", 443, 46),
+		ethernetFrame(EtherTypeIPv6, ipv6Header(net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::2"))),
+	} {
+		acl.HandlePacket(nil, &Packet{CapturedData: data, Length: 100})
+	}
+
+	expected := map[string]int{
+		"permit": 2,
+		"bulk":   1,
+		"drop":   2,
+	}
+	for name, n := range expected {
+		if counts[name] != n {
+			t.Errorf("%s: got %d packets, want %d", name, counts[name], n)
+		}
+	}
+	for i, hits := range []int64{1, 1, 1, 1} {
+		if rules[i].Hits != hits {
+			t.Errorf("rule %d: got %d hits, want %d", i, rules[i].Hits, hits)
+		}
+	}
+	if acl.Unmatched != 1 || acl.UnmatchedBytes != 100 {
+		t.Errorf("got %d unmatched packets (%d bytes), want 1 (100 bytes)", acl.Unmatched, acl.UnmatchedBytes)
+	}
+}
+
+func TestParseACLErrors(t *testing.T) {
+	for _, text := range []string{
+		"allow any",
+		"redirect",
+		"permit src 10.0.0.0",
+		"permit dport 6000-5000",
+		"permit proto foo",
+		"permit vlan 5000",
+		"deny color red",
+	} {
+		if _, err := ParseACL(strings.NewReader(text)); err == nil {
+			t.Errorf("%q: expected an error", text)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package hw
+
+// Member is an egress link that a balancer can pick: a Transmitter, or a
+// group of links such as a LAG.
+type Member interface {
+	LinkUp() bool
+	QueuedBytes() int
+}
+
+// Balancer spreads packets over member links. LAGs, ECMP groups and
+// flowlet balancers are balancers, so any balancing policy can be used for
+// both link aggregation and equal-cost multipath.
+type Balancer interface {
+	Handler
+	Member
+
+	// AddMember adds the link l to the group. Packets for the member are
+	// passed to input, which is expected to lead to l.
+	AddMember(l Member, input Handler)
+}
+
+type balancerMember struct {
+	link  Member
+	input Handler
+}
+
+// balancerMembers is the member list of a balancer.
+type balancerMembers []balancerMember
+
+func (ms *balancerMembers) add(l Member, input Handler) {
+	*ms = append(*ms, balancerMember{
+		link:  l,
+		input: input,
+	})
+}
+
+// LinkUp reports whether any member is up.
+func (ms balancerMembers) LinkUp() bool {
+	for _, m := range ms {
+		if m.link.LinkUp() {
+			return true
+		}
+	}
+	return false
+}
+
+// QueuedBytes returns the number of bytes queued on all members.
+func (ms balancerMembers) QueuedBytes() int {
+	n := 0
+	for _, m := range ms {
+		n += m.link.QueuedBytes()
+	}
+	return n
+}
+
+// hashed returns the member for the hash. If that member is down, the hash
+// picks one of the members that are up and rehashed is true. It returns nil
+// if all members are down. up is a scratch buffer.
+func (ms balancerMembers) hashed(hash uint32, up *[]*balancerMember) (m *balancerMember, rehashed bool) {
+	m = &ms[hash%uint32(len(ms))]
+	if m.link.LinkUp() {
+		return m, false
+	}
+	*up = (*up)[:0]
+	for i := range ms {
+		if ms[i].link.LinkUp() {
+			*up = append(*up, &ms[i])
+		}
+	}
+	if len(*up) == 0 {
+		return nil, false
+	}
+	return (*up)[hash%uint32(len(*up))], true
+}
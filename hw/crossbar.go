@@ -0,0 +1,295 @@
+package hw
+
+import "time"
+
+// Crossbar is an input-queued switch fabric. Packets are queued at the
+// ingress side in virtual output queues (one queue per input/output pair)
+// and moved to the egress side by an iSLIP scheduler. Transfers through the
+// fabric run at Bandwidth*Speedup.
+//
+// Ingress chains are attached with Input, egress ports with Output. The
+// handler returned by Input must (synchronously) lead to a handler returned
+// by Output, so that the crossbar knows which input a packet came from.
+type Crossbar struct {
+	Bandwidth  int64
+	Speedup    float64
+	Iterations int
+
+	// FIFO disables virtual output queues: every input has a single queue
+	// and only its head packet can be scheduled. This models a fabric that
+	// suffers from head-of-line blocking.
+	FIFO bool
+
+	// OnQueueChange, if set, is called whenever the number of bytes in the
+	// queue for (input, output) changes.
+	OnQueueChange func(w *World, input, output int, bytes int)
+
+	inputs  []*crossbarInput
+	outputs []*crossbarOutput
+
+	current   int
+	scheduled bool
+	lastRound time.Duration
+}
+
+type crossbarInput struct {
+	c     *Crossbar
+	idx   int
+	voq   [][]*Packet
+	bytes []int
+	order []int // output indexes in arrival order (FIFO mode only)
+
+	acceptPtr  int
+	busy       bool
+	transfer   *Packet
+	transferTo int
+	matched    int
+
+	// holBlocked is the accumulated time during which queues of this input
+	// had packets for an idle output that the scheduler did not serve.
+	holBlocked time.Duration
+	blocked    int
+}
+
+type crossbarOutput struct {
+	handler  Handler
+	grantPtr int
+	busy     bool
+	granted  int
+}
+
+func NewCrossbar(bandwidth int64, speedup float64, iterations int) *Crossbar {
+	return &Crossbar{
+		Bandwidth:  bandwidth,
+		Speedup:    speedup,
+		Iterations: iterations,
+		current:    -1,
+	}
+}
+
+// Input registers a new ingress port. Packets handled by the returned
+// handler are passed to next, which is expected to dispatch them to one of
+// the crossbar outputs.
+func (c *Crossbar) Input(next Handler) Handler {
+	in := &crossbarInput{
+		c:   c,
+		idx: len(c.inputs),
+	}
+	for range c.outputs {
+		in.voq = append(in.voq, nil)
+		in.bytes = append(in.bytes, 0)
+	}
+	c.inputs = append(c.inputs, in)
+	return HandlerFunc(func(w *World, p *Packet) {
+		prev := c.current
+		c.current = in.idx
+		next.HandlePacket(w, p)
+		c.current = prev
+	})
+}
+
+// Output registers a new egress port. Packets handled by the returned
+// handler are queued at the current input and delivered to h once the
+// fabric has transferred them.
+func (c *Crossbar) Output(h Handler) Handler {
+	idx := len(c.outputs)
+	c.outputs = append(c.outputs, &crossbarOutput{handler: h})
+	for _, in := range c.inputs {
+		in.voq = append(in.voq, nil)
+		in.bytes = append(in.bytes, 0)
+	}
+	return HandlerFunc(func(w *World, p *Packet) {
+		if c.current == -1 {
+			panic("crossbar output reached without crossbar input")
+		}
+		c.enqueue(w, c.inputs[c.current], idx, p)
+	})
+}
+
+// Inputs returns the number of registered ingress ports.
+func (c *Crossbar) Inputs() int {
+	return len(c.inputs)
+}
+
+// HOLBlocked returns the accumulated head-of-line blocking time of input.
+func (c *Crossbar) HOLBlocked(input int) time.Duration {
+	return c.inputs[input].holBlocked
+}
+
+// QueuedBytes returns the number of bytes waiting at input for output.
+func (c *Crossbar) QueuedBytes(input, output int) int {
+	return c.inputs[input].bytes[output]
+}
+
+func (c *Crossbar) enqueue(w *World, in *crossbarInput, out int, p *Packet) {
+	in.voq[out] = append(in.voq[out], p)
+	if c.FIFO {
+		in.order = append(in.order, out)
+	}
+	in.bytes[out] += p.Length
+	if c.OnQueueChange != nil {
+		c.OnQueueChange(w, in.idx, out, in.bytes[out])
+	}
+	c.schedule(w)
+}
+
+func (c *Crossbar) dequeue(w *World, in *crossbarInput, out int) *Packet {
+	p := in.voq[out][0]
+	copy(in.voq[out], in.voq[out][1:])
+	in.voq[out] = in.voq[out][:len(in.voq[out])-1]
+	if c.FIFO {
+		copy(in.order, in.order[1:])
+		in.order = in.order[:len(in.order)-1]
+	}
+	in.bytes[out] -= p.Length
+	if c.OnQueueChange != nil {
+		c.OnQueueChange(w, in.idx, out, in.bytes[out])
+	}
+	return p
+}
+
+func (c *Crossbar) schedule(w *World) {
+	if c.scheduled {
+		return
+	}
+	c.scheduled = true
+	w.At(w.Time(), PrioOutput, c)
+}
+
+// eligible reports whether the queue of in for out may take part in the
+// current scheduling round.
+func (c *Crossbar) eligible(in *crossbarInput, out int) bool {
+	if len(in.voq[out]) == 0 {
+		return false
+	}
+	if c.FIFO {
+		return in.order[0] == out
+	}
+	return true
+}
+
+func (c *Crossbar) Run(w *World) {
+	c.scheduled = false
+
+	now := w.Time()
+	for _, in := range c.inputs {
+		in.holBlocked += time.Duration(in.blocked) * (now - c.lastRound)
+	}
+	c.lastRound = now
+
+	c.islip(w)
+
+	for _, in := range c.inputs {
+		in.blocked = 0
+		if in.busy {
+			continue
+		}
+		for j, out := range c.outputs {
+			if !out.busy && len(in.voq[j]) != 0 {
+				in.blocked++
+			}
+		}
+	}
+}
+
+// islip computes a matching between idle inputs and idle outputs and starts
+// transfers for the matched pairs.
+func (c *Crossbar) islip(w *World) {
+	n := len(c.inputs)
+	m := len(c.outputs)
+	if n == 0 || m == 0 {
+		return
+	}
+
+	iterations := c.Iterations
+	if iterations < 1 {
+		iterations = 1
+	}
+
+	for _, in := range c.inputs {
+		in.matched = -1
+	}
+
+	for iter := 0; iter < iterations; iter++ {
+		// Grant: every free output picks one requesting input, starting
+		// from its grant pointer.
+		for j, out := range c.outputs {
+			out.granted = -1
+			if out.busy {
+				continue
+			}
+			for k := 0; k < n; k++ {
+				i := (out.grantPtr + k) % n
+				in := c.inputs[i]
+				if in.busy || in.matched != -1 || !c.eligible(in, j) {
+					continue
+				}
+				out.granted = i
+				break
+			}
+		}
+
+		// Accept: every free input picks one granting output, starting from
+		// its accept pointer.
+		progress := false
+		for i, in := range c.inputs {
+			if in.busy || in.matched != -1 {
+				continue
+			}
+			for k := 0; k < m; k++ {
+				j := (in.acceptPtr + k) % m
+				out := c.outputs[j]
+				if out.busy || out.granted != i {
+					continue
+				}
+				in.matched = j
+				out.busy = true
+				progress = true
+				if iter == 0 {
+					// Pointers are only updated in the first iteration
+					// to avoid starvation.
+					out.grantPtr = (i + 1) % n
+					in.acceptPtr = (j + 1) % m
+				}
+				break
+			}
+		}
+		if !progress {
+			break
+		}
+	}
+
+	for _, in := range c.inputs {
+		if in.matched != -1 {
+			c.startTransfer(w, in, in.matched)
+		}
+	}
+}
+
+func (c *Crossbar) fabricBandwidth() int64 {
+	if c.Speedup <= 0 {
+		return c.Bandwidth
+	}
+	return int64(float64(c.Bandwidth) * c.Speedup)
+}
+
+func (c *Crossbar) startTransfer(w *World, in *crossbarInput, out int) {
+	p := c.dequeue(w, in, out)
+	in.busy = true
+	in.transfer = p
+	in.transferTo = out
+	w.At(w.Time()+OnWireDuration(p, c.fabricBandwidth()), PrioOutput, in)
+}
+
+func (in *crossbarInput) Run(w *World) {
+	c := in.c
+	p := in.transfer
+	out := c.outputs[in.transferTo]
+
+	in.busy = false
+	in.transfer = nil
+	out.busy = false
+
+	c.schedule(w)
+	out.handler.HandlePacket(w, p)
+}
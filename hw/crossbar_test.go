@@ -0,0 +1,77 @@
+package hw
+
+import (
+	"testing"
+	"time"
+)
+
+// crossbarTest sends packets through a crossbar with two inputs and two
+// outputs. queues lists the outputs of the packets of every input in
+// arrival order. It returns the time at which the last packet left the
+// fabric.
+func crossbarTest(t *testing.T, c *Crossbar, queues [][]int) time.Duration {
+	w := &World{}
+	var last time.Duration
+	var outputs []Handler
+	for i := 0; i < 2; i++ {
+		outputs = append(outputs, c.Output(HandlerFunc(func(w *World, p *Packet) {
+			last = w.Time()
+			p.free()
+		})))
+	}
+	dispatch := HandlerFunc(func(w *World, p *Packet) {
+		outputs[p.CapturedData[0]].HandlePacket(w, p)
+	})
+	var inputs []Handler
+	for range queues {
+		inputs = append(inputs, c.Input(dispatch))
+	}
+
+	for i, queue := range queues {
+		for _, out := range queue {
+			inputs[i].HandlePacket(w, w.ClonePacket(&Packet{CapturedData: []byte{byte(out)}, Length: 1250}))
+		}
+	}
+	w.Simulate()
+
+	if w.cartridge.inUse != 0 {
+		t.Errorf("got %d packets in use, want 0", w.cartridge.inUse)
+	}
+	return last
+}
+
+func TestCrossbar(t *testing.T) {
+	packetTime := OnWireDuration(&Packet{Length: 1250}, 1000*1000*1000)
+
+	// With virtual output queues the second iSLIP iteration matches the
+	// input that lost the first one, so both outputs are always busy.
+	c := NewCrossbar(1000*1000*1000, 1, 2)
+	if last := crossbarTest(t, c, [][]int{{0, 1}, {0, 1}}); last != 2*packetTime {
+		t.Errorf("voq: got last packet at %s, want %s", last, 2*packetTime)
+	}
+	for i := 0; i < 2; i++ {
+		if c.HOLBlocked(i) != 0 {
+			t.Errorf("voq: input %d: got %s of head-of-line blocking, want 0", i, c.HOLBlocked(i))
+		}
+	}
+
+	// A speedup of 2 halves the transfer times.
+	c = NewCrossbar(1000*1000*1000, 2, 2)
+	fastPacketTime := OnWireDuration(&Packet{Length: 1250}, 2*1000*1000*1000)
+	if last := crossbarTest(t, c, [][]int{{0, 1}, {0, 1}}); last != 2*fastPacketTime {
+		t.Errorf("speedup: got last packet at %s, want %s", last, 2*fastPacketTime)
+	}
+
+	// In FIFO mode both heads want output 0. The input that loses is
+	// blocked for a packet time although output 1 is idle, and its last
+	// packet leaves a packet time later than with virtual output queues.
+	// The input that transfers a packet is not blocked.
+	c = NewCrossbar(1000*1000*1000, 1, 2)
+	c.FIFO = true
+	if last := crossbarTest(t, c, [][]int{{0, 1}, {0, 1}}); last != 3*packetTime {
+		t.Errorf("fifo: got last packet at %s, want %s", last, 3*packetTime)
+	}
+	if c.HOLBlocked(0) != 0 || c.HOLBlocked(1) != packetTime {
+		t.Errorf("fifo: got head-of-line blocking %s and %s, want 0 and %s", c.HOLBlocked(0), c.HOLBlocked(1), packetTime)
+	}
+}
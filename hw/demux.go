@@ -0,0 +1,237 @@
+package hw
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// KeyFunc extracts a demultiplexing key from a packet.
+type KeyFunc struct {
+	// Extract appends the key of the packet p with the parsed headers h
+	// (ok is false if the headers could not be parsed) to buf. It returns
+	// false if the packet has no key.
+	Extract func(buf []byte, p *Packet, h *Headers, ok bool) ([]byte, bool)
+
+	// Format returns a name for the key, suitable for use in file names.
+	Format func(key []byte) string
+}
+
+func formatIP(key []byte) string {
+	return net.IP(key).String()
+}
+
+func ipKey(src bool) KeyFunc {
+	return KeyFunc{
+		Extract: func(buf []byte, p *Packet, h *Headers, ok bool) ([]byte, bool) {
+			if !ok {
+				return buf, false
+			}
+			if src {
+				return append(buf, h.SrcIP...), true
+			}
+			return append(buf, h.DstIP...), true
+		},
+		Format: formatIP,
+	}
+}
+
+// prefixKey uses the first bits of the address as the key. IPv4 addresses
+// are truncated to at most 32 bits.
+func prefixKey(src bool, bits int) KeyFunc {
+	return KeyFunc{
+		Extract: func(buf []byte, p *Packet, h *Headers, ok bool) ([]byte, bool) {
+			if !ok {
+				return buf, false
+			}
+			ip := h.DstIP
+			if src {
+				ip = h.SrcIP
+			}
+			n := bits
+			if n > 8*len(ip) {
+				n = 8 * len(ip)
+			}
+			buf = append(buf, byte(n))
+			buf = append(buf, ip.Mask(net.CIDRMask(n, 8*len(ip)))...)
+			return buf, true
+		},
+		Format: func(key []byte) string {
+			return fmt.Sprintf("%s_%d", net.IP(key[1:]), key[0])
+		},
+	}
+}
+
+var fiveTupleKey = KeyFunc{
+	Extract: func(buf []byte, p *Packet, h *Headers, ok bool) ([]byte, bool) {
+		if !ok {
+			return buf, false
+		}
+		buf = append(buf, h.Protocol)
+		buf = append(buf, byte(h.SrcPort>>8), byte(h.SrcPort), byte(h.DstPort>>8), byte(h.DstPort))
+		buf = append(buf, h.SrcIP...)
+		buf = append(buf, h.DstIP...)
+		return buf, true
+	},
+	Format: func(key []byte) string {
+		n := (len(key) - 5) / 2
+		return fmt.Sprintf(
+			"%d_%s_%d_%s_%d",
+			key[0],
+			net.IP(key[5:5+n]), binary.BigEndian.Uint16(key[1:]),
+			net.IP(key[5+n:]), binary.BigEndian.Uint16(key[3:]),
+		)
+	},
+}
+
+var vlanKey = KeyFunc{
+	Extract: func(buf []byte, p *Packet, h *Headers, ok bool) ([]byte, bool) {
+		if !h.HasVLAN {
+			return buf, false
+		}
+		return append(buf, byte(h.VLAN>>8), byte(h.VLAN)), true
+	},
+	Format: func(key []byte) string {
+		return strconv.Itoa(int(binary.BigEndian.Uint16(key)))
+	},
+}
+
+var dscpKey = KeyFunc{
+	Extract: func(buf []byte, p *Packet, h *Headers, ok bool) ([]byte, bool) {
+		if !ok {
+			return buf, false
+		}
+		return append(buf, h.DSCP), true
+	},
+	Format: func(key []byte) string {
+		return strconv.Itoa(int(key[0]))
+	},
+}
+
+// vniKey uses the tunnel type and the virtual network identifier as the key.
+// It only finds keys if the headers were parsed with ParseInner.
+var vniKey = KeyFunc{
+	Extract: func(buf []byte, p *Packet, h *Headers, ok bool) ([]byte, bool) {
+		if !ok || h.Tunnel == TunnelNone {
+			return buf, false
+		}
+		return append(buf, byte(h.Tunnel), byte(h.VNI>>24), byte(h.VNI>>16), byte(h.VNI>>8), byte(h.VNI)), true
+	},
+	Format: func(key []byte) string {
+		return fmt.Sprintf("%s_%d", Tunnel(key[0]), binary.BigEndian.Uint32(key[1:]))
+	},
+}
+
+var mplsLabelKey = KeyFunc{
+	Extract: func(buf []byte, p *Packet, h *Headers, ok bool) ([]byte, bool) {
+		if !h.HasMPLS {
+			return buf, false
+		}
+		return append(buf, byte(h.MPLSLabel>>16), byte(h.MPLSLabel>>8), byte(h.MPLSLabel)), true
+	},
+	Format: func(key []byte) string {
+		return strconv.Itoa(int(key[0])<<16 | int(key[1])<<8 | int(key[2]))
+	},
+}
+
+var inPortKey = KeyFunc{
+	Extract: func(buf []byte, p *Packet, h *Headers, ok bool) ([]byte, bool) {
+		return strconv.AppendInt(buf, int64(p.InPort), 10), true
+	},
+	Format: func(key []byte) string {
+		return string(key)
+	},
+}
+
+// ParseKeyFunc returns the key function with the given name. The available
+// keys are dst-ip, src-ip, dst-prefix/N, src-prefix/N, 5-tuple, vlan, dscp,
+// vni, mpls-label and in-port.
+func ParseKeyFunc(name string) (KeyFunc, error) {
+	switch name {
+	case "dst-ip":
+		return ipKey(false), nil
+	case "src-ip":
+		return ipKey(true), nil
+	case "5-tuple":
+		return fiveTupleKey, nil
+	case "vlan":
+		return vlanKey, nil
+	case "dscp":
+		return dscpKey, nil
+	case "vni":
+		return vniKey, nil
+	case "mpls-label":
+		return mplsLabelKey, nil
+	case "in-port":
+		return inPortKey, nil
+	}
+	for _, kind := range []string{"dst-prefix/", "src-prefix/"} {
+		if !strings.HasPrefix(name, kind) {
+			continue
+		}
+		bits, err := strconv.Atoi(name[len(kind):])
+		if err != nil || bits < 0 || bits > 128 {
+			return KeyFunc{}, fmt.Errorf("invalid prefix length in %q", name)
+		}
+		return prefixKey(kind == "src-prefix/", bits), nil
+	}
+	return KeyFunc{}, fmt.Errorf("unknown key %q", name)
+}
+
+// Demux dispatches packets to outputs by a key. Outputs are created on
+// demand by NewOutput. Packets without a key are passed to Drop.
+//
+// If MaxKeys is positive, at most MaxKeys outputs are created; packets
+// with other keys go to a single overflow output named "overflow". If
+// Decapsulate is set, keys are extracted from the inner headers of tunnel
+// packets.
+type Demux struct {
+	Key         KeyFunc
+	NewOutput   func(name string) Handler
+	Drop        Handler
+	MaxKeys     int
+	Decapsulate bool
+
+	Overflowed int64
+
+	outputs  map[string]Handler
+	overflow Handler
+	headers  Headers
+	buf      []byte
+}
+
+func NewDemux(key KeyFunc, newOutput func(name string) Handler, drop Handler) *Demux {
+	return &Demux{
+		Key:       key,
+		NewOutput: newOutput,
+		Drop:      drop,
+		outputs:   make(map[string]Handler),
+	}
+}
+
+func (d *Demux) HandlePacket(w *World, p *Packet) {
+	ok := d.headers.ParseFor(p.CapturedData, d.Decapsulate)
+	key, ok := d.Key.Extract(d.buf[:0], p, &d.headers, ok)
+	d.buf = key
+	if !ok {
+		d.Drop.HandlePacket(w, p)
+		return
+	}
+
+	h, ok := d.outputs[string(key)]
+	if !ok {
+		if d.MaxKeys > 0 && len(d.outputs) >= d.MaxKeys {
+			if d.overflow == nil {
+				d.overflow = d.NewOutput("overflow")
+			}
+			d.Overflowed++
+			d.overflow.HandlePacket(w, p)
+			return
+		}
+		h = d.NewOutput(d.Key.Format(key))
+		d.outputs[string(key)] = h
+	}
+	h.HandlePacket(w, p)
+}
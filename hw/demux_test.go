@@ -0,0 +1,48 @@
+package hw
+
+import (
+	"net"
+	"testing"
+)
+
+func TestDemux(t *testing.T) {
+	key, err := ParseKeyFunc("dst-prefix/48")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counts := make(map[string]int)
+	demux := NewDemux(key, func(name string) Handler {
+		return HandlerFunc(func(w *World, p *Packet) {
+			counts[name]++
+		})
+	}, HandlerFunc(func(w *World, p *Packet) {
+		counts["drop"]++
+	}))
+	demux.MaxKeys = 2
+
+	src := net.ParseIP("2001:db8::1")
+	for _, dst := range []string{"2001:db8:1::1", "2001:db8:1::2", "2001:db8:2::1", "2001:db8:3::1"} {
+		data := ethernetFrame(EtherTypeIPv6, ipv6Header(src, net.ParseIP(dst)))
+		demux.HandlePacket(nil, &Packet{CapturedData: data})
+	}
+	demux.HandlePacket(nil, &Packet{CapturedData: ethernetFrame(0x0806, make([]byte, 28))})
+
+	expected := map[string]int{
+		"2001:db8:1::_48": 2,
+		"2001:db8:2::_48": 1,
+		"overflow":        1,
+		"drop":            1,
+	}
+	for name, n := range expected {
+		if counts[name] != n {
+			t.Errorf("%s: got %d packets, want %d", name, counts[name], n)
+		}
+	}
+	if len(counts) != len(expected) {
+		t.Errorf("got outputs %v, want %v", counts, expected)
+	}
+	if demux.Overflowed != 1 {
+		t.Errorf("got %d overflowed packets, want 1", demux.Overflowed)
+	}
+}
@@ -0,0 +1,185 @@
+package hw
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"os"
+
+	"github.com/google/gopacket/layers"
+)
+
+// Drop describes why and where a packet was dropped.
+type Drop struct {
+	Reason     string
+	Queue      string
+	QueueBytes int
+}
+
+func (d Drop) String() string {
+	return fmt.Sprintf("reason=%s queue=%s queue_bytes=%d", d.Reason, d.Queue, d.QueueBytes)
+}
+
+// DropHandler receives packets that were dropped by the model. It takes
+// ownership of the packet.
+type DropHandler interface {
+	HandleDrop(w *World, p *Packet, d Drop)
+}
+
+type DropHandlerFunc func(*World, *Packet, Drop)
+
+func (h DropHandlerFunc) HandleDrop(w *World, p *Packet, d Drop) {
+	h(w, p, d)
+}
+
+// NullDropHandler frees dropped packets.
+type NullDropHandler struct{}
+
+func (h NullDropHandler) HandleDrop(w *World, p *Packet, d Drop) {
+	p.free()
+}
+
+const (
+	pcapngBlockSHB = 0x0A0D0D0A
+	pcapngBlockIDB = 0x00000001
+	pcapngBlockEPB = 0x00000006
+
+	pcapngOptEnd     = 0
+	pcapngOptComment = 1
+	pcapngOptTsResol = 9
+)
+
+// DropWriter is a DropHandler that writes dropped packets to a pcapng file.
+// Every record carries a comment with the drop reason, the queue name and
+// the queue occupancy at the moment of the drop.
+type DropWriter struct {
+	// SampleRate makes the writer record only every SampleRate-th drop.
+	// Zero or one means every drop is recorded.
+	SampleRate int
+
+	file  *os.File
+	w     *bufio.Writer
+	count int64
+	err   error
+	buf   []byte
+}
+
+func NewDropWriter(filename string, linkType layers.LinkType) (*DropWriter, error) {
+	file, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	dw := &DropWriter{
+		file: file,
+		w:    bufio.NewWriterSize(file, pcapWriterBufferSize),
+	}
+
+	dw.writeBlock(pcapngBlockSHB, []byte{
+		0x4D, 0x3C, 0x2B, 0x1A, // byte-order magic
+		1, 0, 0, 0, // version 1.0
+		0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, // unknown section length
+	}, nil)
+
+	idb := make([]byte, 8)
+	binary.LittleEndian.PutUint16(idb[0:], uint16(linkType))
+	binary.LittleEndian.PutUint32(idb[4:], pcapSnapLen)
+	dw.writeBlock(pcapngBlockIDB, idb, []pcapngOption{
+		{code: pcapngOptTsResol, value: []byte{9}}, // nanoseconds
+	})
+
+	if dw.err != nil {
+		_ = file.Close()
+		return nil, dw.err
+	}
+
+	return dw, nil
+}
+
+type pcapngOption struct {
+	code  uint16
+	value []byte
+}
+
+func pcapngPad(n int) int {
+	return (4 - n%4) % 4
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	var buf [2]byte
+	binary.LittleEndian.PutUint16(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+func (dw *DropWriter) writeBlock(blockType uint32, body []byte, options []pcapngOption) {
+	if dw.err != nil {
+		return
+	}
+
+	length := 12 + len(body) + pcapngPad(len(body))
+	if len(options) != 0 {
+		for _, o := range options {
+			length += 4 + len(o.value) + pcapngPad(len(o.value))
+		}
+		length += 4 // opt_endofopt
+	}
+
+	b := dw.buf[:0]
+	b = appendUint32(b, blockType)
+	b = appendUint32(b, uint32(length))
+	b = append(b, body...)
+	b = append(b, make([]byte, pcapngPad(len(body)))...)
+	if len(options) != 0 {
+		for _, o := range options {
+			b = appendUint16(b, o.code)
+			b = appendUint16(b, uint16(len(o.value)))
+			b = append(b, o.value...)
+			b = append(b, make([]byte, pcapngPad(len(o.value)))...)
+		}
+		b = appendUint16(b, pcapngOptEnd)
+		b = appendUint16(b, 0)
+	}
+	b = appendUint32(b, uint32(length))
+	dw.buf = b
+
+	_, dw.err = dw.w.Write(b)
+}
+
+func (dw *DropWriter) HandleDrop(w *World, p *Packet, d Drop) {
+	dw.count++
+	if dw.SampleRate <= 1 || dw.count%int64(dw.SampleRate) == 1 {
+		ts := uint64(w.StartTime().Add(w.Time()).UnixNano())
+
+		epb := make([]byte, 20, 20+len(p.CapturedData))
+		binary.LittleEndian.PutUint32(epb[0:], 0) // interface id
+		binary.LittleEndian.PutUint32(epb[4:], uint32(ts>>32))
+		binary.LittleEndian.PutUint32(epb[8:], uint32(ts))
+		binary.LittleEndian.PutUint32(epb[12:], uint32(len(p.CapturedData)))
+		binary.LittleEndian.PutUint32(epb[16:], uint32(p.Length))
+		epb = append(epb, p.CapturedData...)
+
+		dw.writeBlock(pcapngBlockEPB, epb, []pcapngOption{
+			{code: pcapngOptComment, value: []byte(d.String())},
+		})
+	}
+	p.free()
+}
+
+// Close flushes buffered records and closes the file. It returns the first
+// error that occurred while writing records, if any.
+func (dw *DropWriter) Close() error {
+	err := dw.err
+	if ferr := dw.w.Flush(); err == nil {
+		err = ferr
+	}
+	if cerr := dw.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,151 @@
+package hw
+
+import (
+	"net"
+	"testing"
+)
+
+func TestParseHashFields(t *testing.T) {
+	testCases := []struct {
+		s      string
+		fields HashFields
+		ok     bool
+	}{
+		{"5-tuple", HashFiveTuple, true},
+		{"src-ip,dst-ip", HashSrcIP | HashDstIP, true},
+		{"dst-ip, flow-label", HashDstIP | HashFlowLabel, true},
+		{"src-mac", 0, false},
+	}
+	for _, tc := range testCases {
+		fields, err := ParseHashFields(tc.s)
+		if (err == nil) != tc.ok || fields != tc.fields {
+			t.Errorf("%q: got %b, %v; want %b, ok=%v", tc.s, fields, err, tc.fields, tc.ok)
+		}
+	}
+}
+
+func TestFlowHashFields(t *testing.T) {
+	base := Headers{
+		SrcIP:     net.ParseIP("2001:db8::1"),
+		DstIP:     net.ParseIP("2001:db8::2"),
+		Protocol:  ProtocolTCP,
+		SrcPort:   1234,
+		DstPort:   443,
+		FlowLabel: 0x12345,
+	}
+	testCases := []struct {
+		field  HashFields
+		change func(h *Headers)
+	}{
+		{HashSrcIP, func(h *Headers) { h.SrcIP = net.ParseIP("2001:db8::3") }},
+		{HashDstIP, func(h *Headers) { h.DstIP = net.ParseIP("2001:db8::4") }},
+		{HashProtocol, func(h *Headers) { h.Protocol = ProtocolUDP }},
+		{HashSrcPort, func(h *Headers) { h.SrcPort = 4321 }},
+		{HashDstPort, func(h *Headers) { h.DstPort = 80 }},
+		{HashFlowLabel, func(h *Headers) { h.FlowLabel = 0x54321 }},
+	}
+	for _, fn := range HashFuncNames() {
+		for _, tc := range testCases {
+			changed := base
+			tc.change(&changed)
+
+			// The hash changes with a selected field and ignores the
+			// other fields.
+			with, _ := NewFlowHash(tc.field, fn, 0)
+			if with.Sum(&base) == with.Sum(&changed) {
+				t.Errorf("%s: field %b: hash does not depend on the field", fn, tc.field)
+			}
+			without, _ := NewFlowHash((HashFiveTuple|HashFlowLabel)&^tc.field, fn, 0)
+			if without.Sum(&base) != without.Sum(&changed) {
+				t.Errorf("%s: field %b: hash depends on an unselected field", fn, tc.field)
+			}
+		}
+
+		a, _ := NewFlowHash(HashFiveTuple, fn, 1)
+		b, _ := NewFlowHash(HashFiveTuple, fn, 2)
+		if a.Sum(&base) == b.Sum(&base) {
+			t.Errorf("%s: seeds 1 and 2 give the same hash", fn)
+		}
+		if c, _ := NewFlowHash(HashFiveTuple, fn, 1); a.Sum(&base) != c.Sum(&base) {
+			t.Errorf("%s: the same seed gives different hashes", fn)
+		}
+	}
+
+	if _, err := NewFlowHash(HashFiveTuple, "md5", 0); err == nil {
+		t.Error("expected an error for an unknown hash function")
+	}
+}
+
+func TestECMPGroup(t *testing.T) {
+	w := &World{}
+	hash, _ := NewFlowHash(HashFiveTuple, "crc32", 0)
+	g := NewECMPGroup("uplinks", hash)
+
+	members := []*testMember{{up: true}, {up: true}, {up: true}, {up: true}}
+	counts := make([]int, len(members))
+	got := -1
+	for i, m := range members {
+		i := i
+		g.AddMember(m, HandlerFunc(func(w *World, p *Packet) {
+			counts[i]++
+			got = i
+			p.free()
+		}))
+	}
+
+	testCases := []struct {
+		name string
+		down int // a failed member, or -1
+	}{
+		{"all up", -1},
+		{"member 2 down", 2},
+	}
+	for _, tc := range testCases {
+		if tc.down != -1 {
+			members[tc.down].up = false
+		}
+		for i := range counts {
+			counts[i] = 0
+		}
+		const flows = 1000
+		for f := 0; f < flows; f++ {
+			p := flowPacket(w, f)
+			selected := g.Select(p)
+			g.HandlePacket(w, p)
+			if got != selected && selected != tc.down {
+				t.Fatalf("%s: flow %d: got member %d, want %d", tc.name, f, got, selected)
+			}
+		}
+		up := len(members)
+		if tc.down != -1 {
+			up--
+		}
+		for i, n := range counts {
+			if i == tc.down {
+				if n != 0 {
+					t.Errorf("%s: got %d packets on the failed member", tc.name, n)
+				}
+				continue
+			}
+			if want := flows / up; n < want*3/4 || n > want*5/4 {
+				t.Errorf("%s: member %d: got %d flows, want about %d", tc.name, i, n, want)
+			}
+		}
+	}
+	if g.Rehashed == 0 {
+		t.Error("got no rehashed packets")
+	}
+
+	for _, m := range members {
+		m.up = false
+	}
+	var reason string
+	g.Drop = DropHandlerFunc(func(w *World, p *Packet, d Drop) {
+		reason = d.Reason
+		p.free()
+	})
+	g.HandlePacket(w, flowPacket(w, 0))
+	if reason != "all ecmp members down" {
+		t.Errorf("got drop reason %q with all members down, want %q", reason, "all ecmp members down")
+	}
+}
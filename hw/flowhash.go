@@ -0,0 +1,160 @@
+package hw
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"sort"
+	"strings"
+)
+
+// HashFields is a set of header fields that are used to compute a flow
+// hash.
+type HashFields uint
+
+const (
+	HashSrcIP HashFields = 1 << iota
+	HashDstIP
+	HashSrcPort
+	HashDstPort
+	HashProtocol
+	HashFlowLabel
+
+	HashFiveTuple = HashSrcIP | HashDstIP | HashSrcPort | HashDstPort | HashProtocol
+)
+
+var hashFieldNames = map[string]HashFields{
+	"src-ip":     HashSrcIP,
+	"dst-ip":     HashDstIP,
+	"src-port":   HashSrcPort,
+	"dst-port":   HashDstPort,
+	"protocol":   HashProtocol,
+	"flow-label": HashFlowLabel,
+	"5-tuple":    HashFiveTuple,
+}
+
+// ParseHashFields parses a comma-separated list of field names, e.g.
+// "src-ip,dst-ip,flow-label".
+func ParseHashFields(s string) (HashFields, error) {
+	var fields HashFields
+	for _, name := range strings.Split(s, ",") {
+		f, ok := hashFieldNames[strings.TrimSpace(name)]
+		if !ok {
+			return 0, fmt.Errorf("unknown hash field %q", name)
+		}
+		fields |= f
+	}
+	return fields, nil
+}
+
+// HashFunc computes a hash of data. The seed makes it possible to get
+// different hashes for the same data on different switches.
+type HashFunc func(seed uint32, data []byte) uint32
+
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+var HashFuncs = map[string]HashFunc{
+	"crc32": func(seed uint32, data []byte) uint32 {
+		return crc32.Update(seed, crc32.IEEETable, data)
+	},
+	"crc32c": func(seed uint32, data []byte) uint32 {
+		return crc32.Update(seed, castagnoliTable, data)
+	},
+	"fnv1a": func(seed uint32, data []byte) uint32 {
+		h := uint32(2166136261) ^ seed
+		for _, b := range data {
+			h ^= uint32(b)
+			h *= 16777619
+		}
+		return h
+	},
+	"xor": func(seed uint32, data []byte) uint32 {
+		h := seed
+		for i := 0; i+4 <= len(data); i += 4 {
+			h ^= binary.BigEndian.Uint32(data[i:])
+		}
+		var tail [4]byte
+		copy(tail[:], data[len(data)/4*4:])
+		h ^= binary.BigEndian.Uint32(tail[:])
+		return h ^ h>>16
+	},
+}
+
+// HashFuncNames returns the names of the available hash functions.
+func HashFuncNames() []string {
+	var names []string
+	for name := range HashFuncs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// FlowHash computes a hash over the selected header fields of a packet.
+//
+// Groups that take the hash modulo their size pick correlated members if
+// they use the same fields, function and seed: a 2-way group behind a
+// 4-way group only sees flows with an even or an odd hash. For CRC hashes
+// a different seed does not help either, it flips the same bits for every
+// key of a given length. A non-zero Salt scrambles the hash after Func,
+// so that groups with different salts pick their members independently.
+type FlowHash struct {
+	Fields HashFields
+	Func   HashFunc
+	Seed   uint32
+	Salt   uint32
+
+	key []byte
+}
+
+func NewFlowHash(fields HashFields, fn string, seed uint32) (*FlowHash, error) {
+	f, ok := HashFuncs[fn]
+	if !ok {
+		return nil, fmt.Errorf("unknown hash function %q (available: %s)", fn, strings.Join(HashFuncNames(), ", "))
+	}
+	return &FlowHash{
+		Fields: fields,
+		Func:   f,
+		Seed:   seed,
+	}, nil
+}
+
+// Sum returns the hash of the headers h.
+func (fh *FlowHash) Sum(h *Headers) uint32 {
+	key := fh.key[:0]
+	if fh.Fields&HashSrcIP != 0 {
+		key = append(key, h.SrcIP...)
+	}
+	if fh.Fields&HashDstIP != 0 {
+		key = append(key, h.DstIP...)
+	}
+	if fh.Fields&HashProtocol != 0 {
+		key = append(key, h.Protocol)
+	}
+	if fh.Fields&HashSrcPort != 0 {
+		key = append(key, byte(h.SrcPort>>8), byte(h.SrcPort))
+	}
+	if fh.Fields&HashDstPort != 0 {
+		key = append(key, byte(h.DstPort>>8), byte(h.DstPort))
+	}
+	if fh.Fields&HashFlowLabel != 0 {
+		key = append(key, byte(h.FlowLabel>>16), byte(h.FlowLabel>>8), byte(h.FlowLabel))
+	}
+	fh.key = key
+	sum := fh.Func(fh.Seed, key)
+	if fh.Salt != 0 {
+		sum = mix32(sum ^ fh.Salt)
+	}
+	return sum
+}
+
+// mix32 is the finalizer of MurmurHash3, every bit of the result depends on
+// every bit of h.
+func mix32(h uint32) uint32 {
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+	return h
+}
@@ -0,0 +1,108 @@
+package hw
+
+import "time"
+
+const defaultFlowletTableSize = 1 << 16
+
+// FlowletBalancer spreads packets over member links at flowlet
+// granularity: a flow keeps its member until the gap between two of its
+// packets exceeds Timeout, then a new member is picked. With a zero
+// Timeout every packet is balanced independently.
+//
+// New members are picked at random, or, if CongestionAware is set, the
+// member with the lowest queue occupancy is picked. Like in hardware,
+// flows are tracked in a fixed-size table indexed by their hash, so
+// colliding flows share a flowlet.
+type FlowletBalancer struct {
+	Name            string
+	Hash            *FlowHash
+	Timeout         time.Duration
+	CongestionAware bool
+	Drop            DropHandler
+
+	// Flowlets counts path decisions.
+	Flowlets int64
+
+	members balancerMembers
+	table   []flowlet
+	headers Headers
+}
+
+type flowlet struct {
+	valid  bool
+	last   time.Duration
+	member int
+}
+
+func NewFlowletBalancer(name string, hash *FlowHash, timeout time.Duration) *FlowletBalancer {
+	return &FlowletBalancer{
+		Name:    name,
+		Hash:    hash,
+		Timeout: timeout,
+		Drop:    NullDropHandler{},
+		table:   make([]flowlet, defaultFlowletTableSize),
+	}
+}
+
+// AddMember adds the link l to the group. Packets for the member are passed
+// to input, which is expected to lead to l.
+func (b *FlowletBalancer) AddMember(l Member, input Handler) {
+	b.members.add(l, input)
+}
+
+// LinkUp reports whether any member is up.
+func (b *FlowletBalancer) LinkUp() bool {
+	return b.members.LinkUp()
+}
+
+// QueuedBytes returns the number of bytes queued on all members.
+func (b *FlowletBalancer) QueuedBytes() int {
+	return b.members.QueuedBytes()
+}
+
+// pick chooses a member for a new flowlet. It returns -1 if all members are
+// down.
+func (b *FlowletBalancer) pick(w *World) int {
+	best := -1
+	up := 0
+	for i, m := range b.members {
+		if !m.link.LinkUp() {
+			continue
+		}
+		up++
+		if b.CongestionAware {
+			if best == -1 || m.link.QueuedBytes() < b.members[best].link.QueuedBytes() {
+				best = i
+			}
+		} else if w.Rand().Intn(up) == 0 {
+			// Reservoir sampling gives every member that is up the same
+			// chance to be picked.
+			best = i
+		}
+	}
+	return best
+}
+
+func (b *FlowletBalancer) HandlePacket(w *World, p *Packet) {
+	b.headers.Parse(p.CapturedData)
+	f := &b.table[b.Hash.Sum(&b.headers)%uint32(len(b.table))]
+
+	now := w.Time()
+	if !f.valid || now-f.last > b.Timeout || !b.members[f.member].link.LinkUp() {
+		member := b.pick(w)
+		if member == -1 {
+			b.Drop.HandleDrop(w, p, Drop{
+				Reason:     "all members down",
+				Queue:      b.Name,
+				QueueBytes: b.QueuedBytes(),
+			})
+			return
+		}
+		f.valid = true
+		f.member = member
+		b.Flowlets++
+	}
+	f.last = now
+
+	b.members[f.member].input.HandlePacket(w, p)
+}
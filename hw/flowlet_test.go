@@ -0,0 +1,100 @@
+package hw
+
+import (
+	"testing"
+	"time"
+)
+
+type testMember struct {
+	up     bool
+	queued int
+}
+
+func (m *testMember) LinkUp() bool     { return m.up }
+func (m *testMember) QueuedBytes() int { return m.queued }
+
+func TestFlowletBalancer(t *testing.T) {
+	w := &World{}
+	hash, _ := NewFlowHash(HashFiveTuple, "crc32", 0)
+	b := NewFlowletBalancer("uplink", hash, 100*time.Microsecond)
+	b.CongestionAware = true
+
+	members := []*testMember{{true, 3000}, {true, 1000}, {true, 2000}}
+	got := -1
+	for i, m := range members {
+		i := i
+		b.AddMember(m, HandlerFunc(func(w *World, p *Packet) {
+			got = i
+			p.free()
+		}))
+	}
+	dropped := 0
+	b.Drop = DropHandlerFunc(func(w *World, p *Packet, d Drop) {
+		dropped++
+		p.free()
+	})
+
+	send := func(at time.Duration) int {
+		w.time = at
+		got = -1
+		b.HandlePacket(w, flowPacket(w, 1))
+		return got
+	}
+
+	if m := send(0); m != 1 {
+		t.Errorf("got member %d, want the least queued member 1", m)
+	}
+	// Within the timeout the flowlet keeps its member.
+	members[1].queued = 5000
+	if m := send(50 * time.Microsecond); m != 1 {
+		t.Errorf("got member %d within the timeout, want 1", m)
+	}
+	// A longer gap starts a new flowlet on the least queued member.
+	if m := send(200 * time.Microsecond); m != 2 {
+		t.Errorf("got member %d after the timeout, want 2", m)
+	}
+	// A failed member moves the flowlet at once.
+	members[2].up = false
+	if m := send(210 * time.Microsecond); m != 0 {
+		t.Errorf("got member %d after a failure, want 0", m)
+	}
+	if b.Flowlets != 3 {
+		t.Errorf("got %d flowlets, want 3", b.Flowlets)
+	}
+
+	members[0].up = false
+	members[1].up = false
+	if m := send(220 * time.Microsecond); m != -1 || dropped != 1 {
+		t.Errorf("got member %d and %d drops with all members down, want a drop", m, dropped)
+	}
+
+	if w.cartridge.inUse != 0 {
+		t.Errorf("got %d packets in use, want 0", w.cartridge.inUse)
+	}
+}
+
+func TestFlowletBalancerRandom(t *testing.T) {
+	w := &World{}
+	hash, _ := NewFlowHash(HashFiveTuple, "crc32", 0)
+	b := NewFlowletBalancer("uplink", hash, 0)
+
+	counts := make([]int, 3)
+	for i := range counts {
+		i := i
+		b.AddMember(&testMember{up: true}, HandlerFunc(func(w *World, p *Packet) {
+			counts[i]++
+			p.free()
+		}))
+	}
+	// With a zero timeout every packet of the flow is balanced on its
+	// own.
+	for i := 0; i < 300; i++ {
+		w.time = time.Duration(i) * time.Microsecond
+		b.HandlePacket(w, flowPacket(w, 1))
+	}
+	for i, n := range counts {
+		if n < 50 {
+			t.Errorf("member %d: got %d packets, want about 100", i, n)
+		}
+	}
+}
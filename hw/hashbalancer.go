@@ -0,0 +1,90 @@
+package hw
+
+// HashBalancer spreads packets over its members by a hash of their headers.
+// Packets of the same flow always take the same member as long as it is
+// up; packets that hash to a member whose link is down are rehashed over
+// the members that are up. It implements both link aggregation groups and
+// ECMP groups, see NewLAG and NewECMPGroup.
+type HashBalancer struct {
+	Name string
+	Kind string // "lag" or "ecmp", used in drop reasons
+	Hash *FlowHash
+	Drop DropHandler
+
+	// Rehashed counts packets that were moved from a failed member.
+	Rehashed int64
+
+	members balancerMembers
+	up      []*balancerMember
+	headers Headers
+}
+
+func NewHashBalancer(kind, name string, hash *FlowHash) *HashBalancer {
+	return &HashBalancer{
+		Name: name,
+		Kind: kind,
+		Hash: hash,
+		Drop: NullDropHandler{},
+	}
+}
+
+// NewLAG returns a link aggregation group, which presents several member
+// transmitters as one logical egress port.
+func NewLAG(name string, hash *FlowHash) *HashBalancer {
+	return NewHashBalancer("lag", name, hash)
+}
+
+// NewECMPGroup returns a group of equal-cost routes.
+func NewECMPGroup(name string, hash *FlowHash) *HashBalancer {
+	return NewHashBalancer("ecmp", name, hash)
+}
+
+// AddMember adds the link l, usually the transmitter of an egress port, to
+// the group. Packets for the member are passed to input, which is expected
+// to lead to l.
+func (b *HashBalancer) AddMember(l Member, input Handler) {
+	b.members.add(l, input)
+}
+
+// Members returns the links of the group.
+func (b *HashBalancer) Members() []Member {
+	var members []Member
+	for _, m := range b.members {
+		members = append(members, m.link)
+	}
+	return members
+}
+
+// LinkUp reports whether any member is up.
+func (b *HashBalancer) LinkUp() bool {
+	return b.members.LinkUp()
+}
+
+// QueuedBytes returns the number of bytes queued on all members.
+func (b *HashBalancer) QueuedBytes() int {
+	return b.members.QueuedBytes()
+}
+
+// Select returns the index of the member for the packet p, not taking
+// failed members into account.
+func (b *HashBalancer) Select(p *Packet) int {
+	b.headers.Parse(p.CapturedData)
+	return int(b.Hash.Sum(&b.headers) % uint32(len(b.members)))
+}
+
+func (b *HashBalancer) HandlePacket(w *World, p *Packet) {
+	b.headers.Parse(p.CapturedData)
+	m, rehashed := b.members.hashed(b.Hash.Sum(&b.headers), &b.up)
+	if m == nil {
+		b.Drop.HandleDrop(w, p, Drop{
+			Reason:     "all " + b.Kind + " members down",
+			Queue:      b.Name,
+			QueueBytes: b.QueuedBytes(),
+		})
+		return
+	}
+	if rehashed {
+		b.Rehashed++
+	}
+	m.input.HandlePacket(w, p)
+}
@@ -0,0 +1,407 @@
+package hw
+
+import (
+	"encoding/binary"
+	"net"
+)
+
+const (
+	EtherTypeIPv4 = 0x0800
+	EtherTypeIPv6 = 0x86DD
+	EtherTypeVLAN = 0x8100
+	EtherTypeQinQ = 0x88A8
+
+	EtherTypeMPLS          = 0x8847
+	EtherTypeMPLSMulticast = 0x8848
+)
+
+const (
+	ProtocolICMP   = 1
+	ProtocolIPv4   = 4
+	ProtocolTCP    = 6
+	ProtocolUDP    = 17
+	ProtocolIPv6   = 41
+	ProtocolGRE    = 47
+	ProtocolICMPv6 = 58
+	ProtocolSCTP   = 132
+)
+
+// IPv6 extension headers.
+const (
+	ExtHopByHop = 1 << iota
+	ExtRouting
+	ExtFragment
+	ExtDestOpts
+	ExtAH
+	ExtESP
+	ExtMobility
+)
+
+// ExtHeaderNames are the names of the extension header bits, in order.
+var ExtHeaderNames = []string{"hop-by-hop", "routing", "fragment", "destination-options", "ah", "esp", "mobility"}
+
+const (
+	PortVXLAN  = 4789
+	PortGeneve = 6081
+
+	etherTypeTEB = 0x6558 // transparent Ethernet bridging
+)
+
+// Tunnel is an encapsulation that Headers.ParseInner can look into.
+type Tunnel uint8
+
+const (
+	TunnelNone Tunnel = iota
+	TunnelVXLAN
+	TunnelGeneve
+	TunnelGRE
+	TunnelIPinIP // IPv4 or IPv6 in IPv4 or IPv6
+)
+
+func (t Tunnel) String() string {
+	switch t {
+	case TunnelNone:
+		return "none"
+	case TunnelVXLAN:
+		return "vxlan"
+	case TunnelGeneve:
+		return "geneve"
+	case TunnelGRE:
+		return "gre"
+	case TunnelIPinIP:
+		return "ipip"
+	}
+	return "unknown"
+}
+
+// Headers contains the fields of a packet that are used for classification.
+// Addresses point into the captured data of the packet.
+type Headers struct {
+	EtherType uint16
+	L3Offset  int
+
+	// HasVLAN is set if the frame has at least one 802.1Q tag. VLAN is the
+	// identifier of the outermost tag.
+	HasVLAN bool
+	VLAN    uint16
+
+	// HasMPLS is set if the frame carries an MPLS label stack. MPLSLabel is
+	// the top label and MPLSDepth is the number of labels. The fields
+	// below describe the payload of the label stack.
+	HasMPLS   bool
+	MPLSLabel uint32
+	MPLSDepth int
+
+	SrcIP     net.IP
+	DstIP     net.IP
+	Protocol  uint8
+	DSCP      uint8
+	FlowLabel uint32
+
+	// L4Offset is the offset of the upper-layer header, or 0 if the packet
+	// does not carry it.
+	L4Offset int
+
+	// ExtHeaders is the set of IPv6 extension headers of the packet, Protocol
+	// is the upper-layer protocol behind them.
+	ExtHeaders uint8
+
+	// Fragment is set if the packet is a fragment, FragmentOffset is its
+	// offset in bytes. Only the first fragment carries the upper-layer
+	// header.
+	Fragment       bool
+	FragmentOffset int
+
+	// HasPorts is set if the packet carries a TCP, UDP or SCTP header.
+	HasPorts bool
+	SrcPort  uint16
+	DstPort  uint16
+
+	// Tunnel is set by ParseInner if the fields above describe the inner
+	// packet of a tunnel. OuterSrcIP and OuterDstIP are then the addresses
+	// of the tunnel endpoints and VNI is the virtual network identifier of
+	// VXLAN and Geneve or the key of GRE.
+	Tunnel     Tunnel
+	VNI        uint32
+	OuterSrcIP net.IP
+	OuterDstIP net.IP
+
+	mplsOffset int
+}
+
+// Parse parses the Ethernet and IP headers of data. VLAN tags and MPLS
+// labels are skipped. It returns false if the packet is not an IPv4 or
+// IPv6 packet or if it is truncated.
+func (h *Headers) Parse(data []byte) bool {
+	*h = Headers{}
+	return h.parseEthernet(data, 0, true)
+}
+
+// ParseFor calls ParseInner if decap is set and Parse otherwise. It is
+// meant for elements with a Decapsulate option.
+func (h *Headers) ParseFor(data []byte, decap bool) bool {
+	if decap {
+		return h.ParseInner(data)
+	}
+	return h.Parse(data)
+}
+
+// ParseInner is like Parse, but if the packet is a VXLAN, Geneve, GRE or
+// IP-in-IP tunnel packet, it parses the headers of the inner packet. The
+// VLAN of the outer frame is kept. If the inner packet is not an IP packet
+// or is truncated, the outer headers are returned. Only one level of
+// encapsulation is removed.
+func (h *Headers) ParseInner(data []byte) bool {
+	if !h.Parse(data) {
+		return false
+	}
+
+	outer := *h
+	var ok bool
+	switch {
+	case h.Protocol == ProtocolUDP && h.HasPorts && h.DstPort == PortVXLAN:
+		offset := h.L4Offset + 8
+		if len(data) < offset+8 || data[offset]&0x08 == 0 {
+			return true
+		}
+		h.Tunnel = TunnelVXLAN
+		h.VNI = binary.BigEndian.Uint32(data[offset+4:]) >> 8
+		ok = h.parseEthernet(data, offset+8, false)
+	case h.Protocol == ProtocolUDP && h.HasPorts && h.DstPort == PortGeneve:
+		offset := h.L4Offset + 8
+		if len(data) < offset+8 {
+			return true
+		}
+		optLen := int(data[offset]&0x3F) * 4
+		protocol := binary.BigEndian.Uint16(data[offset+2:])
+		h.Tunnel = TunnelGeneve
+		h.VNI = binary.BigEndian.Uint32(data[offset+4:]) >> 8
+		ok = h.parsePayload(data, offset+8+optLen, protocol)
+	case h.Protocol == ProtocolGRE && h.L4Offset != 0:
+		offset := h.L4Offset
+		if len(data) < offset+4 {
+			return true
+		}
+		flags := binary.BigEndian.Uint16(data[offset:])
+		protocol := binary.BigEndian.Uint16(data[offset+2:])
+		offset += 4
+		if flags&0x8000 != 0 { // checksum present
+			offset += 4
+		}
+		h.Tunnel = TunnelGRE
+		if flags&0x2000 != 0 { // key present
+			if len(data) < offset+4 {
+				*h = outer
+				return true
+			}
+			h.VNI = binary.BigEndian.Uint32(data[offset:])
+			offset += 4
+		}
+		if flags&0x1000 != 0 { // sequence number present
+			offset += 4
+		}
+		ok = h.parsePayload(data, offset, protocol)
+	case (h.Protocol == ProtocolIPv4 || h.Protocol == ProtocolIPv6) && h.L4Offset != 0:
+		etherType := uint16(EtherTypeIPv6)
+		if h.Protocol == ProtocolIPv4 {
+			etherType = EtherTypeIPv4
+		}
+		h.Tunnel = TunnelIPinIP
+		ok = h.parseIP(data, h.L4Offset, etherType)
+	default:
+		return true
+	}
+
+	if !ok {
+		*h = outer
+		return true
+	}
+	h.OuterSrcIP = outer.SrcIP
+	h.OuterDstIP = outer.DstIP
+	return true
+}
+
+// parsePayload parses a tunnel payload of the given EtherType.
+func (h *Headers) parsePayload(data []byte, offset int, etherType uint16) bool {
+	if etherType == etherTypeTEB {
+		return h.parseEthernet(data, offset, false)
+	}
+	return h.parseIP(data, offset, etherType)
+}
+
+// parseEthernet parses the Ethernet frame that starts at offset. The VLAN
+// is recorded only for the outer frame.
+func (h *Headers) parseEthernet(data []byte, offset int, outer bool) bool {
+	if len(data) < offset+14 {
+		return false
+	}
+	offset += 12
+	etherType := binary.BigEndian.Uint16(data[offset:])
+	for etherType == EtherTypeVLAN || etherType == EtherTypeQinQ {
+		if len(data) < offset+6 {
+			return false
+		}
+		if outer && !h.HasVLAN {
+			h.HasVLAN = true
+			h.VLAN = binary.BigEndian.Uint16(data[offset+2:]) & 0x0FFF
+		}
+		offset += 4
+		etherType = binary.BigEndian.Uint16(data[offset:])
+	}
+	offset += 2
+	if etherType == EtherTypeMPLS || etherType == EtherTypeMPLSMulticast {
+		return h.parseMPLS(data, offset, outer)
+	}
+	return h.parseIP(data, offset, etherType)
+}
+
+// parseMPLS pops the MPLS label stack that starts at offset. The payload
+// is not identified by the stack, so it is guessed from its first nibble:
+// 4 and 6 are IPv4 and IPv6, 0 is the control word of an Ethernet
+// pseudowire, anything else is an Ethernet pseudowire without a control
+// word. The labels are recorded only for the outer frame.
+func (h *Headers) parseMPLS(data []byte, offset int, outer bool) bool {
+	for {
+		if len(data) < offset+4 {
+			return false
+		}
+		entry := binary.BigEndian.Uint32(data[offset:])
+		if outer {
+			if !h.HasMPLS {
+				h.HasMPLS = true
+				h.MPLSLabel = entry >> 12
+				h.mplsOffset = offset
+			}
+			h.MPLSDepth++
+		}
+		offset += 4
+		if entry&0x100 != 0 { // bottom of stack
+			break
+		}
+	}
+	if len(data) <= offset {
+		return false
+	}
+	switch data[offset] >> 4 {
+	case 4:
+		return h.parseIP(data, offset, EtherTypeIPv4)
+	case 6:
+		return h.parseIP(data, offset, EtherTypeIPv6)
+	case 0:
+		return h.parseEthernet(data, offset+4, false)
+	}
+	return h.parseEthernet(data, offset, false)
+}
+
+// parseIP parses the IP packet of the given EtherType that starts at
+// offset.
+func (h *Headers) parseIP(data []byte, offset int, etherType uint16) bool {
+	h.EtherType = etherType
+	h.L3Offset = offset
+	h.SrcIP, h.DstIP = nil, nil
+	h.Protocol, h.DSCP, h.FlowLabel = 0, 0, 0
+	h.ExtHeaders, h.Fragment, h.FragmentOffset = 0, false, 0
+	h.HasPorts, h.SrcPort, h.DstPort = false, 0, 0
+	h.L4Offset = 0
+
+	if offset > len(data) {
+		return false
+	}
+	data = data[offset:]
+	switch etherType {
+	case EtherTypeIPv4:
+		if len(data) < 20 {
+			return false
+		}
+		h.SrcIP = net.IP(data[12:16])
+		h.DstIP = net.IP(data[16:20])
+		h.Protocol = data[9]
+		h.DSCP = data[1] >> 2
+		ihl := int(data[0]&0x0F) * 4
+		flags := binary.BigEndian.Uint16(data[6:])
+		h.Fragment = flags&0x2000 != 0 || flags&0x1FFF != 0 // more fragments or non-zero offset
+		h.FragmentOffset = int(flags&0x1FFF) * 8
+		if ihl >= 20 && h.FragmentOffset == 0 {
+			h.L4Offset = offset + ihl
+			h.parseL4(data, ihl)
+		}
+		return true
+	case EtherTypeIPv6:
+		if len(data) < 40 {
+			return false
+		}
+		h.SrcIP = net.IP(data[8:24])
+		h.DstIP = net.IP(data[24:40])
+		h.Protocol = data[6]
+		h.DSCP = uint8(binary.BigEndian.Uint16(data[0:])>>6) & 0x3F
+		h.FlowLabel = binary.BigEndian.Uint32(data[0:]) & 0xFFFFF
+		if l4, ok := h.parseExtHeaders(data); ok {
+			h.L4Offset = offset + l4
+			h.parseL4(data, l4)
+		}
+		return true
+	}
+	return false
+}
+
+// parseExtHeaders walks the extension headers of the IPv6 packet data and
+// sets Protocol to the upper-layer protocol. It returns the offset of the
+// upper-layer header and false if the header is not available: the chain
+// is truncated, the packet is a non-first fragment or the payload is
+// encrypted.
+func (h *Headers) parseExtHeaders(data []byte) (int, bool) {
+	offset := 40
+	for {
+		var length int
+		switch h.Protocol {
+		case 0:
+			h.ExtHeaders |= ExtHopByHop
+		case 43:
+			h.ExtHeaders |= ExtRouting
+		case 44:
+			h.ExtHeaders |= ExtFragment
+		case 60:
+			h.ExtHeaders |= ExtDestOpts
+		case 135:
+			h.ExtHeaders |= ExtMobility
+		case 51:
+			h.ExtHeaders |= ExtAH
+		case 50:
+			h.ExtHeaders |= ExtESP
+			return 0, false
+		default:
+			return offset, true
+		}
+		if len(data) < offset+8 {
+			return 0, false
+		}
+		switch h.Protocol {
+		case 44:
+			fragment := binary.BigEndian.Uint16(data[offset+2:])
+			h.Fragment = true
+			h.FragmentOffset = int(fragment & 0xFFF8)
+			length = 8
+		case 51:
+			length = (int(data[offset+1]) + 2) * 4
+		default:
+			length = (int(data[offset+1]) + 1) * 8
+		}
+		h.Protocol = data[offset]
+		offset += length
+		if h.FragmentOffset != 0 {
+			return 0, false
+		}
+	}
+}
+
+func (h *Headers) parseL4(data []byte, offset int) {
+	switch h.Protocol {
+	case ProtocolTCP, ProtocolUDP, ProtocolSCTP:
+		if len(data) < offset+4 {
+			return
+		}
+		h.HasPorts = true
+		h.SrcPort = binary.BigEndian.Uint16(data[offset:])
+		h.DstPort = binary.BigEndian.Uint16(data[offset+2:])
+	}
+}
@@ -0,0 +1,226 @@
+package hw
+
+import (
+	"net"
+	"testing"
+)
+
+func ethernetFrame(etherType uint16, payload []byte) []byte {
+	data := make([]byte, 12, 14+len(payload))
+	data = append(data, byte(etherType>>8), byte(etherType))
+	return append(data, payload...)
+}
+
+func ipv4Header(src, dst net.IP) []byte {
+	h := make([]byte, 20)
+	h[0] = 0x45
+	copy(h[12:], src.To4())
+	copy(h[16:], dst.To4())
+	return h
+}
+
+func ipv6Header(src, dst net.IP) []byte {
+	h := make([]byte, 40)
+	h[0] = 0x60
+	copy(h[8:], src.To16())
+	copy(h[24:], dst.To16())
+	return h
+}
+
+func TestHeadersParse(t *testing.T) {
+	testCases := []struct {
+		name string
+		data []byte
+		ok   bool
+		src  string
+		dst  string
+	}{
+		{
+			name: "ipv4",
+			data: ethernetFrame(EtherTypeIPv4, ipv4Header(net.ParseIP("192.0.2.1"), net.ParseIP("198.51.100.2"))),
+			ok:   true,
+			src:  "192.0.2.1",
+			dst:  "198.51.100.2",
+		},
+		{
+			name: "ipv6",
+			data: ethernetFrame(EtherTypeIPv6, ipv6Header(net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::2"))),
+			ok:   true,
+			src:  "2001:db8::1",
+			dst:  "2001:db8::2",
+		},
+		{
+			name: "ipv4 in vlan",
+			data: ethernetFrame(EtherTypeVLAN, append([]byte{0, 100, 0x08, 0x00}, ipv4Header(net.ParseIP("192.0.2.1"), net.ParseIP("192.0.2.2"))...)),
+			ok:   true,
+			src:  "192.0.2.1",
+			dst:  "192.0.2.2",
+		},
+		{
+			name: "truncated",
+			data: ethernetFrame(EtherTypeIPv6, make([]byte, 20)),
+			ok:   false,
+		},
+		{
+			name: "arp",
+			data: ethernetFrame(0x0806, make([]byte, 28)),
+			ok:   false,
+		},
+	}
+	for _, tc := range testCases {
+		var h Headers
+		ok := h.Parse(tc.data)
+		if ok != tc.ok {
+			t.Errorf("%s: got ok=%v, want %v", tc.name, ok, tc.ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if h.SrcIP.String() != tc.src || h.DstIP.String() != tc.dst {
+			t.Errorf("%s: got %s -> %s, want %s -> %s", tc.name, h.SrcIP, h.DstIP, tc.src, tc.dst)
+		}
+	}
+}
+
+func TestHeadersParsePorts(t *testing.T) {
+	ip := ipv4Header(net.ParseIP("192.0.2.1"), net.ParseIP("192.0.2.2"))
+	ip[9] = ProtocolTCP
+	tcp := []byte{0x04, 0xD2, 0x01, 0xBB}
+
+	var h Headers
+	if !h.Parse(ethernetFrame(EtherTypeIPv4, append(ip, tcp...))) {
+		t.Fatal("failed to parse")
+	}
+	if !h.HasPorts || h.SrcPort != 1234 || h.DstPort != 443 {
+		t.Errorf("got ports %v %d -> %d, want 1234 -> 443", h.HasPorts, h.SrcPort, h.DstPort)
+	}
+
+	// Non-first fragments do not carry the transport header.
+	ip[6], ip[7] = 0x00, 0x10
+	if !h.Parse(ethernetFrame(EtherTypeIPv4, append(ip, tcp...))) {
+		t.Fatal("failed to parse fragment")
+	}
+	if h.HasPorts {
+		t.Errorf("got ports for a non-first fragment")
+	}
+}
+
+func TestHeadersParseInner(t *testing.T) {
+	vtepA, vtepB := net.ParseIP("192.0.2.1"), net.ParseIP("192.0.2.2")
+	innerSrc, innerDst := net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::2")
+
+	inner := ipv6Header(innerSrc, innerDst)
+	inner[6] = ProtocolTCP
+	inner = append(inner, 0x04, 0xD2, 0x01, 0xBB)
+
+	udp := func(dport uint16, payload []byte) []byte {
+		ip := ipv4Header(vtepA, vtepB)
+		ip[9] = ProtocolUDP
+		ip = append(ip, 0xC0, 0x00, byte(dport>>8), byte(dport), 0, 0, 0, 0)
+		return ethernetFrame(EtherTypeIPv4, append(ip, payload...))
+	}
+
+	vxlan := append([]byte{0x08, 0, 0, 0, 0, 0x01, 0x02, 0}, ethernetFrame(EtherTypeIPv6, inner)...)
+	geneve := append([]byte{0x01, 0, 0x86, 0xDD, 0, 0, 0x07, 0, 0, 0, 0, 0}, inner...)
+
+	gre := ipv4Header(vtepA, vtepB)
+	gre[9] = ProtocolGRE
+	gre = append(gre, 0x20, 0x00, 0x86, 0xDD, 0, 0, 0, 42)
+	gre = append(gre, inner...)
+
+	ip6in6 := ipv6Header(net.ParseIP("2001:db8:ff::1"), net.ParseIP("2001:db8:ff::2"))
+	ip6in6[6] = ProtocolIPv6
+	ip6in6 = append(ip6in6, inner...)
+
+	ip4 := ipv4Header(net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2"))
+	ip4in6 := ipv6Header(net.ParseIP("2001:db8:ff::1"), net.ParseIP("2001:db8:ff::2"))
+	ip4in6[6] = ProtocolIPv4
+	ip4in6 = append(ip4in6, ip4...)
+
+	testCases := []struct {
+		name   string
+		data   []byte
+		tunnel Tunnel
+		vni    uint32
+		dst    net.IP
+	}{
+		{"vxlan", udp(PortVXLAN, vxlan), TunnelVXLAN, 0x0102, innerDst},
+		{"geneve", udp(PortGeneve, geneve), TunnelGeneve, 0x07, innerDst},
+		{"gre", ethernetFrame(EtherTypeIPv4, gre), TunnelGRE, 42, innerDst},
+		{"ip6in6", ethernetFrame(EtherTypeIPv6, ip6in6), TunnelIPinIP, 0, innerDst},
+		{"ip4in6", ethernetFrame(EtherTypeIPv6, ip4in6), TunnelIPinIP, 0, net.ParseIP("10.0.0.2").To4()},
+		{"truncated", udp(PortVXLAN, vxlan[:30]), TunnelNone, 0, vtepB.To4()},
+		{"plain", udp(53, vxlan), TunnelNone, 0, vtepB.To4()},
+	}
+	for _, tc := range testCases {
+		var h Headers
+		if !h.ParseInner(tc.data) {
+			t.Errorf("%s: failed to parse", tc.name)
+			continue
+		}
+		if h.Tunnel != tc.tunnel || h.VNI != tc.vni || !h.DstIP.Equal(tc.dst) {
+			t.Errorf("%s: got tunnel %s, vni %d, dst %s; want %s, %d, %s", tc.name, h.Tunnel, h.VNI, h.DstIP, tc.tunnel, tc.vni, tc.dst)
+		}
+		if tc.tunnel != TunnelNone && tc.dst.Equal(innerDst) && (!h.HasPorts || h.DstPort != 443) {
+			t.Errorf("%s: got inner ports %v %d, want 443", tc.name, h.HasPorts, h.DstPort)
+		}
+		if tc.tunnel == TunnelNone && h.OuterDstIP != nil {
+			t.Errorf("%s: got outer address %s for a plain packet", tc.name, h.OuterDstIP)
+		}
+
+		// Parse never looks into tunnels.
+		if !h.Parse(tc.data) || h.Tunnel != TunnelNone {
+			t.Errorf("%s: Parse decapsulated the packet", tc.name)
+		}
+		if !h.ParseFor(tc.data, false) || h.Tunnel != TunnelNone {
+			t.Errorf("%s: ParseFor without decap decapsulated the packet", tc.name)
+		}
+		if !h.ParseFor(tc.data, true) || h.Tunnel != tc.tunnel {
+			t.Errorf("%s: ParseFor with decap: got tunnel %s, want %s", tc.name, h.Tunnel, tc.tunnel)
+		}
+	}
+}
+
+func TestHeadersParseExtHeaders(t *testing.T) {
+	tcp := []byte{0x04, 0xD2, 0x01, 0xBB}
+	hopByHop := []byte{60, 0, 0, 0, 0, 0, 0, 0}
+	destOpts := []byte{44, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	fragment := func(offset uint16) []byte {
+		return []byte{ProtocolTCP, 0, byte(offset >> 8), byte(offset) | 1, 0, 0, 0, 1}
+	}
+	packet := func(exts ...[]byte) []byte {
+		ip := ipv6Header(net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::2"))
+		ip[6] = 0
+		for _, ext := range exts {
+			ip = append(ip, ext...)
+		}
+		return ethernetFrame(EtherTypeIPv6, append(ip, tcp...))
+	}
+
+	var h Headers
+	if !h.Parse(packet(hopByHop, destOpts, fragment(0))) {
+		t.Fatal("failed to parse")
+	}
+	if want := uint8(ExtHopByHop | ExtDestOpts | ExtFragment); h.ExtHeaders != want {
+		t.Errorf("got extension headers %b, want %b", h.ExtHeaders, want)
+	}
+	if h.Protocol != ProtocolTCP || !h.HasPorts || h.SrcPort != 1234 || h.DstPort != 443 {
+		t.Errorf("got protocol %d ports %v %d -> %d, want tcp 1234 -> 443", h.Protocol, h.HasPorts, h.SrcPort, h.DstPort)
+	}
+	if !h.Fragment || h.FragmentOffset != 0 {
+		t.Errorf("got fragment %v at %d, want first fragment", h.Fragment, h.FragmentOffset)
+	}
+
+	if !h.Parse(packet(hopByHop, destOpts, fragment(1480))) {
+		t.Fatal("failed to parse fragment")
+	}
+	if !h.Fragment || h.FragmentOffset != 1480 || h.HasPorts {
+		t.Errorf("got fragment %v at %d with ports %v, want non-first fragment at 1480 without ports", h.Fragment, h.FragmentOffset, h.HasPorts)
+	}
+
+	// A truncated chain hides the upper-layer header.
+	if !h.Parse(packet(hopByHop)[:14+40+4]) || h.HasPorts {
+		t.Errorf("got ports for a truncated extension header")
+	}
+}
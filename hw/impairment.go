@@ -0,0 +1,198 @@
+package hw
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GilbertElliott is a two-state bursty loss model. In the good state
+// packets are lost with probability LossGood, in the bad state with
+// probability LossBad. P is the probability of moving from the good state
+// to the bad one, R is the probability of moving back.
+type GilbertElliott struct {
+	P        float64
+	R        float64
+	LossGood float64
+	LossBad  float64
+
+	bad bool
+}
+
+// Impairment degrades the traffic that passes through it, like netem does
+// for a real link. It can lose, corrupt, duplicate and reorder packets. All
+// random decisions are made with World.Rand.
+type Impairment struct {
+	Output Handler
+	Drop   DropHandler
+
+	// Loss is the probability that a packet is lost. It is ignored if
+	// GilbertElliott is set.
+	Loss           float64
+	GilbertElliott *GilbertElliott
+
+	// Corrupt is the probability that a random bit of the captured data is
+	// flipped.
+	Corrupt float64
+
+	// Duplicate is the probability that a packet is delivered twice.
+	Duplicate float64
+
+	// Reorder is the probability that a packet is held back for
+	// ReorderDelay, so that packets behind it overtake it.
+	Reorder      float64
+	ReorderDelay time.Duration
+
+	Lost       int64
+	Corrupted  int64
+	Duplicated int64
+	Reordered  int64
+}
+
+func NewImpairment(output Handler) *Impairment {
+	return &Impairment{
+		Output: output,
+		Drop:   NullDropHandler{},
+	}
+}
+
+// Configure sets up the impairment from a netem-like specification, for
+// example
+//
+//	loss 1% corrupt 0.1% duplicate 0.5% reorder 2% 100us
+//	loss gemodel 1% 30% 100% 0%
+//
+// where the gemodel parameters are p, r, 1-h and 1-k (the loss
+// probabilities in the bad and in the good state).
+func (imp *Impairment) Configure(spec string) error {
+	fields := strings.Fields(spec)
+	prob := func(i int) (float64, error) {
+		if i >= len(fields) {
+			return 0, fmt.Errorf("%s: missing probability", fields[0])
+		}
+		return parseProbability(fields[i])
+	}
+	for len(fields) != 0 {
+		var err error
+		n := 2
+		switch fields[0] {
+		case "loss":
+			if len(fields) > 1 && fields[1] == "gemodel" {
+				ge := &GilbertElliott{LossBad: 1}
+				params := []*float64{&ge.P, &ge.R, &ge.LossBad, &ge.LossGood}
+				for k, param := range params {
+					if n >= len(fields) || (k >= 2 && !isProbability(fields[n])) {
+						break
+					}
+					*param, err = parseProbability(fields[n])
+					if err != nil {
+						return err
+					}
+					n++
+				}
+				if n < 4 {
+					return fmt.Errorf("loss gemodel: missing probability")
+				}
+				imp.GilbertElliott = ge
+			} else {
+				imp.Loss, err = prob(1)
+			}
+		case "corrupt":
+			imp.Corrupt, err = prob(1)
+		case "duplicate":
+			imp.Duplicate, err = prob(1)
+		case "reorder":
+			imp.Reorder, err = prob(1)
+			if err == nil {
+				if len(fields) < 3 {
+					return fmt.Errorf("reorder: missing delay")
+				}
+				imp.ReorderDelay, err = time.ParseDuration(fields[2])
+				n = 3
+			}
+		default:
+			return fmt.Errorf("unknown impairment %q", fields[0])
+		}
+		if err != nil {
+			return err
+		}
+		fields = fields[n:]
+	}
+	return nil
+}
+
+func isProbability(s string) bool {
+	_, err := parseProbability(s)
+	return err == nil
+}
+
+func parseProbability(s string) (float64, error) {
+	scale := 1.0
+	v := s
+	if strings.HasSuffix(v, "%") {
+		scale = 100
+		v = v[:len(v)-1]
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || f < 0 || f/scale > 1 {
+		return 0, fmt.Errorf("invalid probability %q", s)
+	}
+	return f / scale, nil
+}
+
+func (imp *Impairment) lose(w *World) bool {
+	rnd := w.Rand()
+	ge := imp.GilbertElliott
+	if ge == nil {
+		return imp.Loss > 0 && rnd.Float64() < imp.Loss
+	}
+	if ge.bad {
+		if rnd.Float64() < ge.R {
+			ge.bad = false
+		}
+	} else {
+		if rnd.Float64() < ge.P {
+			ge.bad = true
+		}
+	}
+	if ge.bad {
+		return rnd.Float64() < ge.LossBad
+	}
+	return ge.LossGood > 0 && rnd.Float64() < ge.LossGood
+}
+
+func (imp *Impairment) HandlePacket(w *World, p *Packet) {
+	rnd := w.Rand()
+
+	if imp.lose(w) {
+		imp.Lost++
+		imp.Drop.HandleDrop(w, p, Drop{Reason: "impairment loss"})
+		return
+	}
+
+	if imp.Corrupt > 0 && len(p.CapturedData) != 0 && rnd.Float64() < imp.Corrupt {
+		bit := rnd.Intn(8 * len(p.CapturedData))
+		p.CapturedData[bit/8] ^= 1 << uint(bit%8)
+		imp.Corrupted++
+	}
+
+	var dup *Packet
+	if imp.Duplicate > 0 && rnd.Float64() < imp.Duplicate {
+		dup = w.ClonePacket(p)
+		imp.Duplicated++
+	}
+
+	if imp.Reorder > 0 && rnd.Float64() < imp.Reorder {
+		imp.Reordered++
+		w.At(w.Time()+imp.ReorderDelay, PrioInput, RunnerFunc(func(w *World) {
+			imp.Output.HandlePacket(w, p)
+		}))
+	} else {
+		imp.Output.HandlePacket(w, p)
+	}
+
+	if dup != nil {
+		imp.Output.HandlePacket(w, dup)
+	}
+}
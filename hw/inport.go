@@ -0,0 +1,20 @@
+package hw
+
+// InPortSetter marks packets with the number of the port they were received
+// on.
+type InPortSetter struct {
+	Port   int
+	Output Handler
+}
+
+func NewInPortSetter(port int, output Handler) *InPortSetter {
+	return &InPortSetter{
+		Port:   port,
+		Output: output,
+	}
+}
+
+func (s *InPortSetter) HandlePacket(w *World, p *Packet) {
+	p.InPort = s.Port
+	s.Output.HandlePacket(w, p)
+}
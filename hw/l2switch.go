@@ -0,0 +1,139 @@
+package hw
+
+import "time"
+
+// L2Switch is a transparent learning bridge. It learns source MAC addresses
+// per ingress port, forwards frames by destination MAC address and floods
+// broadcast, multicast and unknown unicast frames to all other ports.
+// Entries that are not refreshed within AgingTime are removed.
+type L2Switch struct {
+	AgingTime time.Duration
+	Drop      Handler
+
+	Flooded      int64
+	FloodedBytes int64
+	Filtered     int64
+
+	ports     []l2Port
+	table     map[[6]byte]macEntry
+	nextSweep time.Duration
+}
+
+type l2Port struct {
+	egress  Handler
+	replica Handler
+}
+
+type macEntry struct {
+	port     int
+	lastSeen time.Duration
+}
+
+func NewL2Switch(agingTime time.Duration) *L2Switch {
+	return &L2Switch{
+		AgingTime: agingTime,
+		Drop:      NullHandler{},
+		table:     make(map[[6]byte]macEntry),
+	}
+}
+
+// AddPort adds a port that sends frames to egress. Copies of flooded frames
+// are sent to replica instead, so that they can be accounted as new packets
+// (replica may be the same handler as egress). AddPort returns the handler
+// for frames that are received on the port.
+func (s *L2Switch) AddPort(egress, replica Handler) Handler {
+	port := len(s.ports)
+	s.ports = append(s.ports, l2Port{
+		egress:  egress,
+		replica: replica,
+	})
+	return HandlerFunc(func(w *World, p *Packet) {
+		s.handlePacket(w, port, p)
+	})
+}
+
+// TableSize returns the number of entries in the MAC address table.
+func (s *L2Switch) TableSize() int {
+	return len(s.table)
+}
+
+// sweep removes aged entries. It runs at most once per AgingTime, so an
+// entry may outlive its aging time by up to AgingTime.
+func (s *L2Switch) sweep(now time.Duration) {
+	if now < s.nextSweep {
+		return
+	}
+	for mac, e := range s.table {
+		if now-e.lastSeen > s.AgingTime {
+			delete(s.table, mac)
+		}
+	}
+	s.nextSweep = now + s.AgingTime
+}
+
+func (s *L2Switch) handlePacket(w *World, port int, p *Packet) {
+	data := p.CapturedData
+	if len(data) < 14 {
+		s.Drop.HandlePacket(w, p)
+		return
+	}
+
+	now := w.Time()
+	s.sweep(now)
+
+	var dst, src [6]byte
+	copy(dst[:], data[0:6])
+	copy(src[:], data[6:12])
+
+	if src[0]&1 == 0 {
+		s.table[src] = macEntry{port: port, lastSeen: now}
+	}
+
+	if dst[0]&1 == 0 {
+		if e, ok := s.table[dst]; ok && now-e.lastSeen <= s.AgingTime {
+			if e.port == port {
+				s.Filtered++
+				s.Drop.HandlePacket(w, p)
+				return
+			}
+			s.ports[e.port].egress.HandlePacket(w, p)
+			return
+		}
+	}
+
+	s.flood(w, port, p)
+}
+
+func (s *L2Switch) flood(w *World, ingress int, p *Packet) {
+	last := -1
+	for i := range s.ports {
+		if i != ingress {
+			last = i
+		}
+	}
+	if last == -1 {
+		s.Drop.HandlePacket(w, p)
+		return
+	}
+
+	s.Flooded++
+	s.FloodedBytes += int64(p.Length)
+
+	// All references are taken before the first copy is sent, as a copy
+	// may be freed right away.
+	for i := range s.ports {
+		if i != ingress && i != last {
+			p.Ref()
+		}
+	}
+	for i, port := range s.ports {
+		if i == ingress {
+			continue
+		}
+		if i == last {
+			port.egress.HandlePacket(w, p)
+		} else {
+			port.replica.HandlePacket(w, p)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package hw
+
+import (
+	"net"
+	"testing"
+)
+
+// flowPacket returns a TCP packet of the flow with the given number.
+func flowPacket(w *World, flow int) *Packet {
+	ip := ipv4Header(net.IPv4(10, 0, byte(flow>>8), byte(flow)), net.ParseIP("192.0.2.1"))
+	ip[9] = ProtocolTCP
+	data := ethernetFrame(EtherTypeIPv4, append(ip, 0xC0, 0x00, 0x01, 0xBB))
+	return w.ClonePacket(&Packet{CapturedData: data, Length: len(data)})
+}
+
+func TestLAG(t *testing.T) {
+	w := &World{}
+	hash, _ := NewFlowHash(HashFiveTuple, "crc32", 0)
+	lag := NewLAG("uplink", hash)
+
+	var members []*Transmitter
+	var got int
+	for i := 0; i < 4; i++ {
+		i := i
+		tr := NewTransmitter(1000*1000*1000, NullHandler{})
+		members = append(members, tr)
+		lag.AddMember(tr, HandlerFunc(func(w *World, p *Packet) {
+			got = i
+			p.free()
+		}))
+	}
+
+	const flows = 200
+	picked := make([]int, flows)
+	used := make(map[int]int)
+	for f := 0; f < flows; f++ {
+		lag.HandlePacket(w, flowPacket(w, f))
+		picked[f] = got
+		used[got]++
+
+		lag.HandlePacket(w, flowPacket(w, f))
+		if got != picked[f] {
+			t.Fatalf("flow %d: got members %d and %d, want the same member", f, picked[f], got)
+		}
+	}
+	if len(used) != 4 {
+		t.Errorf("got %d members in use, want 4", len(used))
+	}
+
+	// Flows of a failed member are rehashed to the survivors, the other
+	// flows stay where they are.
+	members[1].SetLinkUp(w, false)
+	for f := 0; f < flows; f++ {
+		lag.HandlePacket(w, flowPacket(w, f))
+		if got == 1 {
+			t.Fatalf("flow %d: got failed member", f)
+		}
+		if picked[f] != 1 && got != picked[f] {
+			t.Errorf("flow %d: moved from member %d to %d", f, picked[f], got)
+		}
+	}
+	if lag.Rehashed != int64(used[1]) {
+		t.Errorf("got %d rehashed packets, want %d", lag.Rehashed, used[1])
+	}
+
+	if w.cartridge.inUse != 0 {
+		t.Errorf("got %d packets in use, want 0", w.cartridge.inUse)
+	}
+}
+
+func TestFlowHashSalt(t *testing.T) {
+	w := &World{}
+	ecmp, _ := NewFlowHash(HashFiveTuple, "crc32", 0)
+	lag, _ := NewFlowHash(HashFiveTuple, "crc32", 1)
+
+	// The flows that a 4-way ECMP group sends to its first member all pick
+	// the same member of a 2-way LAG unless the LAG hash is salted.
+	count := func() map[uint32]int {
+		counts := make(map[uint32]int)
+		var h Headers
+		for f := 0; f < 1000; f++ {
+			p := flowPacket(w, f)
+			h.Parse(p.CapturedData)
+			if ecmp.Sum(&h)%4 == 0 {
+				counts[lag.Sum(&h)%2]++
+			}
+			p.free()
+		}
+		return counts
+	}
+	if counts := count(); len(counts) != 1 {
+		t.Errorf("unsalted: got members %v, want a single member", counts)
+	}
+	lag.Salt = 0x4c414731
+	if counts := count(); len(counts) != 2 || counts[0] < 100 || counts[1] < 100 {
+		t.Errorf("salted: got members %v, want both members", counts)
+	}
+}
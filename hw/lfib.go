@@ -0,0 +1,261 @@
+package hw
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+type LabelAction int
+
+const (
+	LabelSwap LabelAction = iota
+	LabelPop
+)
+
+func (a LabelAction) String() string {
+	switch a {
+	case LabelSwap:
+		return "swap"
+	case LabelPop:
+		return "pop"
+	}
+	return fmt.Sprintf("LabelAction(%d)", int(a))
+}
+
+// LabelEntry is an entry of a label forwarding information base.
+type LabelEntry struct {
+	Label    uint32
+	Action   LabelAction
+	OutLabel uint32 // the new top label of LabelSwap
+	Port     string
+
+	Packets int64
+	Bytes   int64
+}
+
+// LFIB maps incoming MPLS labels to forwarding actions.
+type LFIB struct {
+	Entries []*LabelEntry
+
+	labels map[uint32]*LabelEntry
+}
+
+func NewLFIB() *LFIB {
+	return &LFIB{
+		labels: make(map[uint32]*LabelEntry),
+	}
+}
+
+// Add adds the entry e to the table, replacing an entry for the same label.
+func (t *LFIB) Add(e *LabelEntry) {
+	if old, ok := t.labels[e.Label]; ok {
+		for i, entry := range t.Entries {
+			if entry == old {
+				t.Entries[i] = e
+			}
+		}
+	} else {
+		t.Entries = append(t.Entries, e)
+	}
+	t.labels[e.Label] = e
+}
+
+// Lookup returns the entry for the label, or nil.
+func (t *LFIB) Lookup(label uint32) *LabelEntry {
+	return t.labels[label]
+}
+
+// LoadLFIB reads a label forwarding information base from a file. See
+// ParseLFIB for the format.
+func LoadLFIB(filename string) (*LFIB, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	t, err := ParseLFIB(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+	return t, nil
+}
+
+func parseLabel(s string) (uint32, error) {
+	n, err := strconv.ParseUint(s, 10, 20)
+	if err != nil {
+		return 0, fmt.Errorf("invalid label %q", s)
+	}
+	return uint32(n), nil
+}
+
+// ParseLFIB reads a label forwarding information base, one entry per line:
+//
+//	# label  action     port
+//	100      swap 200   core1
+//	101      pop        edge1
+//
+// swap replaces the top label, pop removes it. Labels are numbers between
+// 0 and 1048575.
+func ParseLFIB(r io.Reader) (*LFIB, error) {
+	t := NewLFIB()
+	scanner := bufio.NewScanner(r)
+	lineno := 0
+	for scanner.Scan() {
+		lineno++
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i != -1 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("line %d: expected label, action and port", lineno)
+		}
+
+		label, err := parseLabel(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", lineno, err)
+		}
+		e := &LabelEntry{Label: label}
+		switch fields[1] {
+		case "swap":
+			if len(fields) != 4 {
+				return nil, fmt.Errorf("line %d: swap: expected new label and port", lineno)
+			}
+			e.Action = LabelSwap
+			e.OutLabel, err = parseLabel(fields[2])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s", lineno, err)
+			}
+			e.Port = fields[3]
+		case "pop":
+			if len(fields) != 3 {
+				return nil, fmt.Errorf("line %d: pop: expected port", lineno)
+			}
+			e.Action = LabelPop
+			e.Port = fields[2]
+		default:
+			return nil, fmt.Errorf("line %d: unknown action %q", lineno, fields[1])
+		}
+		t.Add(e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// LabelSwitch forwards MPLS packets to egress ports by their top label.
+// Ports are created on demand by NewOutput. Unlabeled packets and packets
+// with a label that is not in the table are counted and passed to Drop.
+//
+// The label stack is rewritten in the captured data of the packet. When
+// the last label is popped, the EtherType of the frame is set to IPv4 or
+// IPv6, or the frame is replaced by the Ethernet frame of a pseudowire.
+type LabelSwitch struct {
+	Table     *LFIB
+	NewOutput func(port string) Handler
+	Drop      Handler
+
+	Unlabeled    int64
+	UnknownLabel int64
+
+	// OnResize, if set, is called when popping a label changes the length
+	// of a packet by delta bytes. It keeps buffers that the packet has
+	// already entered in step with its new length.
+	OnResize func(w *World, p *Packet, delta int)
+
+	ports   map[string]Handler
+	headers Headers
+}
+
+func NewLabelSwitch(table *LFIB, newOutput func(port string) Handler, drop Handler) *LabelSwitch {
+	return &LabelSwitch{
+		Table:     table,
+		NewOutput: newOutput,
+		Drop:      drop,
+		ports:     make(map[string]Handler),
+	}
+}
+
+func (s *LabelSwitch) HandlePacket(w *World, p *Packet) {
+	s.headers.Parse(p.CapturedData)
+	if !s.headers.HasMPLS {
+		s.Unlabeled++
+		s.Drop.HandlePacket(w, p)
+		return
+	}
+	e := s.Table.Lookup(s.headers.MPLSLabel)
+	if e == nil {
+		s.UnknownLabel++
+		s.Drop.HandlePacket(w, p)
+		return
+	}
+
+	if p.refs > 0 {
+		c := w.ClonePacket(p)
+		p.free()
+		p = c
+	}
+	e.Packets++
+	e.Bytes += int64(p.Length)
+	switch e.Action {
+	case LabelSwap:
+		offset := s.headers.mplsOffset
+		entry := binary.BigEndian.Uint32(p.CapturedData[offset:])
+		binary.BigEndian.PutUint32(p.CapturedData[offset:], e.OutLabel<<12|entry&0xFFF)
+	case LabelPop:
+		length := p.Length
+		s.pop(p)
+		if s.OnResize != nil && p.Length != length {
+			s.OnResize(w, p, p.Length-length)
+		}
+	}
+
+	h, ok := s.ports[e.Port]
+	if !ok {
+		h = s.NewOutput(e.Port)
+		s.ports[e.Port] = h
+	}
+	h.HandlePacket(w, p)
+}
+
+// pop removes the top label of the packet.
+func (s *LabelSwitch) pop(p *Packet) {
+	data := p.CapturedData
+	offset := s.headers.mplsOffset
+	bottom := s.headers.MPLSDepth == 1
+	if bottom {
+		payload := offset + 4
+		etherType := uint16(0)
+		switch {
+		case s.headers.EtherType == EtherTypeIPv4 && s.headers.L3Offset == payload:
+			etherType = EtherTypeIPv4
+		case s.headers.EtherType == EtherTypeIPv6 && s.headers.L3Offset == payload:
+			etherType = EtherTypeIPv6
+		}
+		if etherType == 0 {
+			// Ethernet pseudowire, the inner frame replaces the outer one.
+			if len(data) > payload && data[payload]>>4 == 0 {
+				payload += 4
+			}
+			if payload > len(data) {
+				payload = len(data)
+			}
+			p.CapturedData = append(data[:0], data[payload:]...)
+			p.Length -= payload
+			return
+		}
+		binary.BigEndian.PutUint16(data[offset-2:], etherType)
+	}
+	p.CapturedData = append(data[:offset], data[offset+4:]...)
+	p.Length -= 4
+}
@@ -0,0 +1,105 @@
+package hw
+
+import (
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+)
+
+func mplsEntry(label uint32, bottom bool) []byte {
+	entry := label<<12 | 64
+	if bottom {
+		entry |= 0x100
+	}
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, entry)
+	return b
+}
+
+func TestLabelSwitch(t *testing.T) {
+	table, err := ParseLFIB(strings.NewReader(`
+# label  action     port
+100      swap 200   core1
+101      pop        edge1
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(map[string]*Packet)
+	output := func(name string) Handler {
+		return HandlerFunc(func(w *World, p *Packet) {
+			got[name] = p
+		})
+	}
+	drop := 0
+	s := NewLabelSwitch(table, output, HandlerFunc(func(w *World, p *Packet) {
+		drop++
+	}))
+
+	ip := ipv4Header(net.ParseIP("192.0.2.1"), net.ParseIP("192.0.2.2"))
+	labeled := func(labels ...uint32) []byte {
+		var stack []byte
+		for i, label := range labels {
+			stack = append(stack, mplsEntry(label, i == len(labels)-1)...)
+		}
+		return ethernetFrame(EtherTypeMPLS, append(stack, ip...))
+	}
+
+	for _, data := range [][]byte{
+		labeled(100, 16),
+		labeled(101),
+		labeled(102),
+		ethernetFrame(EtherTypeIPv4, ip),
+	} {
+		s.HandlePacket(nil, &Packet{CapturedData: data, Length: len(data)})
+	}
+
+	var h Headers
+	if p := got["core1"]; p == nil {
+		t.Error("core1: no packet")
+	} else if !h.Parse(p.CapturedData) || h.MPLSLabel != 200 || h.MPLSDepth != 2 {
+		t.Errorf("core1: got label %d and depth %d, want 200 and 2", h.MPLSLabel, h.MPLSDepth)
+	}
+	if p := got["edge1"]; p == nil {
+		t.Error("edge1: no packet")
+	} else if !h.Parse(p.CapturedData) || h.HasMPLS || h.EtherType != EtherTypeIPv4 || p.Length != 14+20 {
+		t.Errorf("edge1: got mpls=%v ethertype %#x length %d, want an IPv4 packet of 34 bytes", h.HasMPLS, h.EtherType, p.Length)
+	}
+	if drop != 2 || s.UnknownLabel != 1 || s.Unlabeled != 1 {
+		t.Errorf("got %d dropped, %d unknown and %d unlabeled packets, want 2, 1 and 1", drop, s.UnknownLabel, s.Unlabeled)
+	}
+	if table.Lookup(100).Packets != 1 || table.Lookup(101).Packets != 1 {
+		t.Errorf("got %d and %d packets for labels 100 and 101, want 1 and 1", table.Lookup(100).Packets, table.Lookup(101).Packets)
+	}
+}
+
+func TestHeadersParseMPLS(t *testing.T) {
+	ip := ipv6Header(net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::2"))
+	inner := ethernetFrame(EtherTypeIPv4, ipv4Header(net.ParseIP("192.0.2.1"), net.ParseIP("192.0.2.2")))
+	copy(inner, []byte{0x52, 0x54, 0x00, 0x12, 0x34, 0x56}) // a first nibble that is not 0, 4 or 6
+
+	testCases := []struct {
+		name string
+		data []byte
+		src  string
+	}{
+		{"ipv6", ethernetFrame(EtherTypeMPLS, append(append(mplsEntry(16, false), mplsEntry(17, true)...), ip...)), "2001:db8::1"},
+		{"pseudowire", ethernetFrame(EtherTypeMPLS, append(mplsEntry(16, true), inner...)), "192.0.2.1"},
+		{"pseudowire with control word", ethernetFrame(EtherTypeMPLS, append(append(mplsEntry(16, true), 0, 0, 0, 0), inner...)), "192.0.2.1"},
+	}
+	for _, tc := range testCases {
+		var h Headers
+		if !h.Parse(tc.data) {
+			t.Errorf("%s: failed to parse", tc.name)
+			continue
+		}
+		if !h.HasMPLS || h.MPLSLabel != 16 {
+			t.Errorf("%s: got mpls=%v label %d, want label 16", tc.name, h.HasMPLS, h.MPLSLabel)
+		}
+		if h.SrcIP.String() != tc.src {
+			t.Errorf("%s: got source %s, want %s", tc.name, h.SrcIP, tc.src)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package hw
+
+import "time"
+
+// Link delivers packets to Output after the propagation delay of the cable.
+// Serialization is modelled by the Transmitter that feeds the link, so a
+// link does not queue packets and keeps their order.
+type Link struct {
+	Delay  time.Duration
+	Output Handler
+
+	Packets int64
+	Bytes   int64
+}
+
+func NewLink(delay time.Duration, output Handler) *Link {
+	return &Link{
+		Delay:  delay,
+		Output: output,
+	}
+}
+
+func (l *Link) HandlePacket(w *World, p *Packet) {
+	l.Packets++
+	l.Bytes += int64(p.Length)
+	if l.Delay == 0 {
+		l.Output.HandlePacket(w, p)
+		return
+	}
+	w.At(w.Time()+l.Delay, PrioInput, RunnerFunc(func(w *World) {
+		l.Output.HandlePacket(w, p)
+	}))
+}
@@ -0,0 +1,102 @@
+package hw
+
+import (
+	"bufio"
+	"os"
+	"strings"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+const (
+	pcapWriterBufferSize = 1 << 20
+	pcapSnapLen          = 262144
+)
+
+type packetWriter interface {
+	WritePacket(ci gopacket.CaptureInfo, data []byte) error
+}
+
+// PcapWriter writes every packet that passes through it to a capture file
+// and hands it over to Output. The capture timestamp is the simulated time
+// at which the packet reached the writer, so a PcapWriter placed after a
+// Transmitter records departure times.
+//
+// Files with the .pcapng extension are written in the pcapng format, all
+// other files are written as pcap with nanosecond timestamps.
+type PcapWriter struct {
+	Output Handler
+
+	file *os.File
+	buf  *bufio.Writer
+	ng   *pcapgo.NgWriter
+	w    packetWriter
+	err  error
+}
+
+func NewPcapWriter(filename string, linkType layers.LinkType, output Handler) (*PcapWriter, error) {
+	file, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	pw := &PcapWriter{
+		Output: output,
+		file:   file,
+		buf:    bufio.NewWriterSize(file, pcapWriterBufferSize),
+	}
+
+	if strings.HasSuffix(filename, ".pcapng") {
+		pw.ng, err = pcapgo.NewNgWriter(pw.buf, linkType)
+		pw.w = pw.ng
+	} else {
+		w := pcapgo.NewWriterNanos(pw.buf)
+		err = w.WriteFileHeader(pcapSnapLen, linkType)
+		pw.w = w
+	}
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+
+	return pw, nil
+}
+
+func (pw *PcapWriter) HandlePacket(w *World, p *Packet) {
+	pw.WritePacket(gopacket.CaptureInfo{
+		Timestamp:     w.StartTime().Add(w.Time()),
+		CaptureLength: len(p.CapturedData),
+		Length:        p.Length,
+	}, p.CapturedData)
+	pw.Output.HandlePacket(w, p)
+}
+
+// WritePacket writes a packet with the given capture info to the file
+// without passing it to Output. It can be used to write packets that do not
+// come from a simulation.
+func (pw *PcapWriter) WritePacket(ci gopacket.CaptureInfo, data []byte) error {
+	if pw.err == nil {
+		pw.err = pw.w.WritePacket(ci, data)
+	}
+	return pw.err
+}
+
+// Close flushes buffered packets and closes the file. It returns the first
+// error that occurred while writing packets, if any.
+func (pw *PcapWriter) Close() error {
+	err := pw.err
+	if pw.ng != nil {
+		if ferr := pw.ng.Flush(); err == nil {
+			err = ferr
+		}
+	}
+	if ferr := pw.buf.Flush(); err == nil {
+		err = ferr
+	}
+	if cerr := pw.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -14,10 +14,26 @@ type Packet struct {
 	CapturedData []byte
 	Timestamp    time.Time
 	Length       int
+	InPort       int
 	returnTo     *Cartridge
+	refs         int
+}
+
+// Ref adds a reference to the packet and returns it, so that the same packet
+// can be handed over to several handlers. Every reference is freed
+// separately, the packet returns to its cartridge when the last one is
+// freed. A packet with several references is shared and must not be
+// modified; use World.ClonePacket to get a copy that can be modified.
+func (p *Packet) Ref() *Packet {
+	p.refs++
+	return p
 }
 
 func (p *Packet) free() {
+	if p.refs > 0 {
+		p.refs--
+		return
+	}
 	p.returnTo.Release()
 }
 
@@ -45,6 +61,7 @@ func (c *Cartridge) NewPacket() *Packet {
 	atomic.AddInt32(&c.inUse, 1)
 
 	p := &c.Packets[idx]
+	p.refs = 0
 	if p.CapturedData != nil {
 		p.CapturedData = p.CapturedData[:0]
 	}
@@ -101,21 +118,24 @@ func NewReceiver(w *World, source gopacket.PacketDataSource, linkType layers.Lin
 
 	r.getBuffer()
 	if len(r.buffer) == 0 {
-		panic("no data?")
+		// The source is empty, e.g. a filter did not match any packet.
+		return r
 	}
 	w.AtStart(r.buffer[0].Timestamp, r)
 
 	return r
 }
 
-func (r *Receiver) newPacket() *Packet {
-	if r.cartridge == nil {
-		r.cartridge = cartridgeFree.Get().(*Cartridge)
+// newPacket takes a packet from the cartridge c, replacing the cartridge with
+// a new one when it is exhausted.
+func newPacket(c **Cartridge) *Packet {
+	if *c == nil {
+		*c = cartridgeFree.Get().(*Cartridge)
 	}
-	p := r.cartridge.NewPacket()
+	p := (*c).NewPacket()
 	if p == nil {
-		r.cartridge = cartridgeFree.Get().(*Cartridge)
-		p = r.cartridge.NewPacket()
+		*c = cartridgeFree.Get().(*Cartridge)
+		p = (*c).NewPacket()
 		if p == nil {
 			panic("got nil from new cartridge")
 		}
@@ -123,6 +143,10 @@ func (r *Receiver) newPacket() *Packet {
 	return p
 }
 
+func (r *Receiver) newPacket() *Packet {
+	return newPacket(&r.cartridge)
+}
+
 func (r *Receiver) packetsToChannel(ch chan<- []*Packet, source gopacket.PacketDataSource, bandwidth int64) {
 	var nextTimestamp time.Time
 	buffer := <-r.bufferFree
@@ -148,6 +172,7 @@ func (r *Receiver) packetsToChannel(ch chan<- []*Packet, source gopacket.PacketD
 			p.Timestamp = ci.Timestamp
 		}
 		p.Length = ci.Length
+		p.InPort = 0
 
 		nextTimestamp = p.Timestamp.Add(OnWireDuration(p, bandwidth))
 
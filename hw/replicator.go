@@ -0,0 +1,236 @@
+package hw
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+)
+
+// ReplicationGroup is a multicast group or the broadcast domain together with
+// the egress ports that receive its packets.
+type ReplicationGroup struct {
+	Name  string
+	Ports []string
+
+	// Packets is the number of packets that were replicated, Copies is the
+	// number of packets that were sent to the ports, so Copies/Packets is
+	// the replication factor. ReplicaBytes is the number of bytes in the
+	// copies beyond the first one, i.e. the buffer cost of replication.
+	Packets      int64
+	Copies       int64
+	ReplicaBytes int64
+}
+
+// ReplicationTable maps multicast destination addresses to replication
+// groups.
+type ReplicationTable struct {
+	Groups []*ReplicationGroup
+
+	// Broadcast is the group for frames sent to the Ethernet broadcast
+	// address. Default is the group for multicast addresses without a
+	// group of their own. Both may be nil.
+	Broadcast *ReplicationGroup
+	Default   *ReplicationGroup
+
+	byAddr map[string]*ReplicationGroup
+}
+
+func NewReplicationTable() *ReplicationTable {
+	return &ReplicationTable{
+		byAddr: make(map[string]*ReplicationGroup),
+	}
+}
+
+// IsMulticast reports whether ip is an IPv4 (224.0.0.0/4) or an IPv6
+// (ff00::/8) multicast address.
+func IsMulticast(ip net.IP) bool {
+	switch len(ip) {
+	case net.IPv4len:
+		return ip[0]&0xF0 == 0xE0
+	case net.IPv6len:
+		return ip[0] == 0xFF
+	}
+	return false
+}
+
+var broadcastMAC = []byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}
+
+// Add adds the group g for the multicast address ip.
+func (t *ReplicationTable) Add(ip net.IP, g *ReplicationGroup) {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	t.Groups = append(t.Groups, g)
+	t.byAddr[string(ip)] = g
+}
+
+// Lookup returns the replication group for a frame with the parsed headers h
+// (ok is false if the headers could not be parsed), or nil if the frame is
+// not a multicast or broadcast frame. unknown is true if the frame is a
+// multicast or broadcast frame without a group.
+func (t *ReplicationTable) Lookup(data []byte, h *Headers, ok bool) (g *ReplicationGroup, unknown bool) {
+	if ok && IsMulticast(h.DstIP) {
+		if g, found := t.byAddr[string(h.DstIP)]; found {
+			return g, false
+		}
+		return t.Default, t.Default == nil
+	}
+	if len(data) >= 6 && bytes.Equal(data[0:6], broadcastMAC) {
+		return t.Broadcast, t.Broadcast == nil
+	}
+	return nil, false
+}
+
+// LoadReplicationTable reads replication groups from a file. See
+// ParseReplicationTable for the format.
+func LoadReplicationTable(filename string) (*ReplicationTable, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	t, err := ParseReplicationTable(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+	return t, nil
+}
+
+// ParseReplicationTable reads replication groups, one group per line:
+//
+//	# group       ports
+//	ff02::1       port0,port1,port2
+//	239.1.1.1     port1,port2
+//	broadcast     port0,port1,port2
+//	default       port3
+//
+// The group "broadcast" receives frames sent to the Ethernet broadcast
+// address, the group "default" receives multicast packets for addresses
+// that are not listed.
+func ParseReplicationTable(r io.Reader) (*ReplicationTable, error) {
+	t := NewReplicationTable()
+	scanner := bufio.NewScanner(r)
+	lineno := 0
+	for scanner.Scan() {
+		lineno++
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i != -1 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: expected group and ports", lineno)
+		}
+
+		g := &ReplicationGroup{
+			Name:  fields[0],
+			Ports: strings.Split(fields[1], ","),
+		}
+		switch fields[0] {
+		case "broadcast":
+			t.Groups = append(t.Groups, g)
+			t.Broadcast = g
+		case "default":
+			t.Groups = append(t.Groups, g)
+			t.Default = g
+		default:
+			ip := net.ParseIP(fields[0])
+			if ip == nil {
+				return nil, fmt.Errorf("line %d: invalid group %q", lineno, fields[0])
+			}
+			if !IsMulticast(ip.To4()) && !IsMulticast(ip) {
+				return nil, fmt.Errorf("line %d: %s is not a multicast address", lineno, fields[0])
+			}
+			t.Add(ip, g)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Replicator sends multicast and broadcast packets to every port of their
+// replication group. The packet is shared between the ports by reference,
+// the first port receives it from NewOutput, the others from NewReplica, so
+// that the copies can be accounted as new packets (NewReplica may return the
+// same handler as NewOutput). Other packets are passed to Output. Multicast
+// and broadcast packets without a group are counted as unknown and passed
+// to Drop.
+//
+// Several Replicators may share the same table, the counters of the groups
+// are then the totals over all of them.
+type Replicator struct {
+	Table      *ReplicationTable
+	Output     Handler
+	NewOutput  func(port string) Handler
+	NewReplica func(port string) Handler
+	Drop       Handler
+
+	Unknown int64
+
+	outputs  map[string]Handler
+	replicas map[string]Handler
+	headers  Headers
+}
+
+func NewReplicator(table *ReplicationTable, output Handler, newOutput, newReplica func(port string) Handler, drop Handler) *Replicator {
+	return &Replicator{
+		Table:      table,
+		Output:     output,
+		NewOutput:  newOutput,
+		NewReplica: newReplica,
+		Drop:       drop,
+		outputs:    make(map[string]Handler),
+		replicas:   make(map[string]Handler),
+	}
+}
+
+func cachedOutput(cache map[string]Handler, newOutput func(string) Handler, port string) Handler {
+	h, ok := cache[port]
+	if !ok {
+		h = newOutput(port)
+		cache[port] = h
+	}
+	return h
+}
+
+func (r *Replicator) HandlePacket(w *World, p *Packet) {
+	ok := r.headers.Parse(p.CapturedData)
+	g, unknown := r.Table.Lookup(p.CapturedData, &r.headers, ok)
+	if g == nil {
+		if unknown {
+			r.Unknown++
+			r.Drop.HandlePacket(w, p)
+		} else {
+			r.Output.HandlePacket(w, p)
+		}
+		return
+	}
+
+	n := len(g.Ports)
+	g.Packets++
+	g.Copies += int64(n)
+	g.ReplicaBytes += int64(n-1) * int64(p.Length)
+
+	// All references are taken before the first copy is sent, as a copy
+	// may be freed right away.
+	for i := 1; i < n; i++ {
+		p.Ref()
+	}
+	for i, port := range g.Ports {
+		if i == 0 {
+			cachedOutput(r.outputs, r.NewOutput, port).HandlePacket(w, p)
+		} else {
+			cachedOutput(r.replicas, r.NewReplica, port).HandlePacket(w, p)
+		}
+	}
+}
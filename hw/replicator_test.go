@@ -0,0 +1,69 @@
+package hw
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestReplicator(t *testing.T) {
+	table, err := ParseReplicationTable(strings.NewReader(`
+ff02::1      port0,port1,port2
+239.1.1.1    port1,port2
+broadcast    port0,port1
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := &World{}
+	counts := make(map[string]int)
+	counter := func(prefix string) func(string) Handler {
+		return func(name string) Handler {
+			return HandlerFunc(func(w *World, p *Packet) {
+				counts[prefix+name]++
+				p.free()
+			})
+		}
+	}
+	r := NewReplicator(table, counter("")("unicast"), counter(""), counter("replica:"), counter("")("drop"))
+
+	src := net.ParseIP("2001:db8::1")
+	for _, data := range [][]byte{
+		ethernetFrame(EtherTypeIPv6, ipv6Header(src, net.ParseIP("ff02::1"))),
+		ethernetFrame(EtherTypeIPv6, ipv6Header(src, net.ParseIP("ff02::2"))),
+		ethernetFrame(EtherTypeIPv6, ipv6Header(src, net.ParseIP("2001:db8::2"))),
+		ethernetFrame(EtherTypeIPv4, ipv4Header(net.ParseIP("192.0.2.1"), net.ParseIP("239.1.1.1"))),
+		append([]byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}, make([]byte, 50)...),
+	} {
+		p := w.ClonePacket(&Packet{CapturedData: data, Length: 100})
+		r.HandlePacket(w, p)
+	}
+
+	expected := map[string]int{
+		"port0":         2,
+		"port1":         1,
+		"replica:port1": 2,
+		"replica:port2": 2,
+		"unicast":       1,
+		"drop":          1,
+	}
+	for name, n := range expected {
+		if counts[name] != n {
+			t.Errorf("%s: got %d packets, want %d", name, counts[name], n)
+		}
+	}
+	if r.Unknown != 1 {
+		t.Errorf("got %d unknown packets, want 1", r.Unknown)
+	}
+
+	g := table.Groups[0]
+	if g.Packets != 1 || g.Copies != 3 || g.ReplicaBytes != 200 {
+		t.Errorf("%s: got packets=%d copies=%d replica_bytes=%d, want 1, 3, 200", g.Name, g.Packets, g.Copies, g.ReplicaBytes)
+	}
+
+	// Every reference has been freed, so no packet is in use.
+	if w.cartridge.inUse != 0 {
+		t.Errorf("got %d packets in use, want 0", w.cartridge.inUse)
+	}
+}
@@ -0,0 +1,187 @@
+package hw
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+)
+
+// Route is an entry of a RouteTable.
+type Route struct {
+	Prefix  *net.IPNet
+	Port    string
+	NextHop net.IP
+}
+
+type routeNode struct {
+	children [2]*routeNode
+	route    *Route
+}
+
+// RouteTable is a longest-prefix-match table for IPv4 and IPv6 routes.
+type RouteTable struct {
+	v4 routeNode
+	v6 routeNode
+}
+
+func NewRouteTable() *RouteTable {
+	return &RouteTable{}
+}
+
+// root returns the trie for the address family of ip. Addresses of length
+// 4 are IPv4 addresses, all other addresses are treated as IPv6.
+func (t *RouteTable) root(ip net.IP) (*routeNode, net.IP) {
+	if len(ip) == net.IPv4len {
+		return &t.v4, ip
+	}
+	return &t.v6, ip.To16()
+}
+
+// Add adds the route r to the table, replacing a route with the same prefix.
+func (t *RouteTable) Add(r *Route) {
+	ones, _ := r.Prefix.Mask.Size()
+	node, addr := t.root(r.Prefix.IP)
+	for i := 0; i < ones; i++ {
+		bit := addr[i/8] >> uint(7-i%8) & 1
+		if node.children[bit] == nil {
+			node.children[bit] = &routeNode{}
+		}
+		node = node.children[bit]
+	}
+	node.route = r
+}
+
+// Lookup returns the route with the longest prefix that contains ip, or nil
+// if there is no such route.
+func (t *RouteTable) Lookup(ip net.IP) *Route {
+	node, addr := t.root(ip)
+	if addr == nil {
+		return nil
+	}
+	route := node.route
+	for i := 0; i < 8*len(addr); i++ {
+		bit := addr[i/8] >> uint(7-i%8) & 1
+		node = node.children[bit]
+		if node == nil {
+			break
+		}
+		if node.route != nil {
+			route = node.route
+		}
+	}
+	return route
+}
+
+// LoadRoutes reads a route table from a file. See ParseRoutes for the
+// format.
+func LoadRoutes(filename string) (*RouteTable, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	t, err := ParseRoutes(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+	return t, nil
+}
+
+// ParseRoutes reads a route table, one route per line:
+//
+//	# prefix        port     [next hop]
+//	192.0.2.0/24    uplink1  198.51.100.1
+//	2001:db8::/32   uplink2
+//	default         uplink1
+//
+// The prefix "default" adds both 0.0.0.0/0 and ::/0.
+func ParseRoutes(r io.Reader) (*RouteTable, error) {
+	t := NewRouteTable()
+	scanner := bufio.NewScanner(r)
+	lineno := 0
+	for scanner.Scan() {
+		lineno++
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i != -1 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("line %d: expected prefix, port and optional next hop", lineno)
+		}
+
+		var nextHop net.IP
+		if len(fields) == 3 {
+			nextHop = net.ParseIP(fields[2])
+			if nextHop == nil {
+				return nil, fmt.Errorf("line %d: invalid next hop %q", lineno, fields[2])
+			}
+		}
+
+		prefixes := []string{fields[0]}
+		if fields[0] == "default" {
+			prefixes = []string{"0.0.0.0/0", "::/0"}
+		}
+		for _, prefix := range prefixes {
+			_, ipnet, err := net.ParseCIDR(prefix)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s", lineno, err)
+			}
+			t.Add(&Route{
+				Prefix:  ipnet,
+				Port:    fields[1],
+				NextHop: nextHop,
+			})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Router forwards IP packets to egress ports according to a route table.
+// Ports are created on demand by NewOutput. Packets without a route and
+// non-IP packets are counted as unrouted and passed to Drop.
+type Router struct {
+	Table     *RouteTable
+	NewOutput func(port string) Handler
+	Drop      Handler
+
+	Unrouted int64
+
+	ports   map[string]Handler
+	headers Headers
+}
+
+func NewRouter(table *RouteTable, newOutput func(port string) Handler, drop Handler) *Router {
+	return &Router{
+		Table:     table,
+		NewOutput: newOutput,
+		Drop:      drop,
+		ports:     make(map[string]Handler),
+	}
+}
+
+func (r *Router) HandlePacket(w *World, p *Packet) {
+	if r.headers.Parse(p.CapturedData) {
+		if route := r.Table.Lookup(r.headers.DstIP); route != nil {
+			h, ok := r.ports[route.Port]
+			if !ok {
+				h = r.NewOutput(route.Port)
+				r.ports[route.Port] = h
+			}
+			h.HandlePacket(w, p)
+			return
+		}
+	}
+	r.Unrouted++
+	r.Drop.HandlePacket(w, p)
+}
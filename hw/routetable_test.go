@@ -0,0 +1,56 @@
+package hw
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestRouteTableLookup(t *testing.T) {
+	table, err := ParseRoutes(strings.NewReader(`
+default          uplink0
+192.0.2.0/24     port1
+192.0.2.128/25   port2   198.51.100.1
+2001:db8::/32    port3
+2001:db8:1::/48  port4
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		ip   net.IP
+		port string
+	}{
+		{net.ParseIP("192.0.2.1").To4(), "port1"},
+		{net.ParseIP("192.0.2.200").To4(), "port2"},
+		{net.ParseIP("203.0.113.1").To4(), "uplink0"},
+		{net.ParseIP("2001:db8::1"), "port3"},
+		{net.ParseIP("2001:db8:1::1"), "port4"},
+		{net.ParseIP("2001:db9::1"), "uplink0"},
+	}
+	for _, tc := range testCases {
+		route := table.Lookup(tc.ip)
+		if route == nil {
+			t.Errorf("%s: no route", tc.ip)
+			continue
+		}
+		if route.Port != tc.port {
+			t.Errorf("%s: got port %s, want %s", tc.ip, route.Port, tc.port)
+		}
+	}
+
+	if route := table.Lookup(net.ParseIP("192.0.2.200").To4()); !route.NextHop.Equal(net.ParseIP("198.51.100.1")) {
+		t.Errorf("got next hop %s, want 198.51.100.1", route.NextHop)
+	}
+}
+
+func TestRouteTableNoDefault(t *testing.T) {
+	table := NewRouteTable()
+	_, prefix, _ := net.ParseCIDR("10.0.0.0/8")
+	table.Add(&Route{Prefix: prefix, Port: "port1"})
+
+	if route := table.Lookup(net.ParseIP("11.0.0.1").To4()); route != nil {
+		t.Errorf("got route %+v, want nil", route)
+	}
+}
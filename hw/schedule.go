@@ -0,0 +1,190 @@
+package hw
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type ScheduleAction int
+
+const (
+	ScheduleLinkDown ScheduleAction = iota
+	ScheduleLinkUp
+	ScheduleBandwidth
+)
+
+// ScheduleEvent is a change of the state of a port at the given simulated
+// time (relative to the world start time).
+type ScheduleEvent struct {
+	Time      time.Duration
+	Port      string
+	Action    ScheduleAction
+	Policy    LinkDownPolicy
+	Bandwidth int64
+}
+
+// Schedule is a scenario of link failures and bandwidth changes. The file
+// format is one event per line:
+//
+//	# time  port          action [argument]
+//	10s     2001:db8::1   down   [drop|hold]
+//	10.5s   2001:db8::1   up
+//	1m      *             bandwidth 1G
+//
+// The port "*" matches every port.
+type Schedule struct {
+	Events []ScheduleEvent
+}
+
+func LoadSchedule(filename string) (*Schedule, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	s, err := ParseSchedule(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+	return s, nil
+}
+
+func ParseSchedule(r io.Reader) (*Schedule, error) {
+	s := &Schedule{}
+	scanner := bufio.NewScanner(r)
+	lineno := 0
+	for scanner.Scan() {
+		lineno++
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i != -1 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		e, err := parseScheduleEvent(fields)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", lineno, err)
+		}
+		s.Events = append(s.Events, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func parseScheduleEvent(fields []string) (ScheduleEvent, error) {
+	var e ScheduleEvent
+	if len(fields) < 3 {
+		return e, fmt.Errorf("expected time, port and action")
+	}
+
+	var err error
+	e.Time, err = time.ParseDuration(fields[0])
+	if err != nil {
+		return e, err
+	}
+	e.Port = fields[1]
+
+	args := fields[3:]
+	switch fields[2] {
+	case "down":
+		e.Action = ScheduleLinkDown
+		if len(args) > 1 {
+			return e, fmt.Errorf("too many arguments for down")
+		}
+		if len(args) == 1 {
+			switch args[0] {
+			case "drop":
+				e.Policy = LinkDownDrop
+			case "hold":
+				e.Policy = LinkDownHold
+			default:
+				return e, fmt.Errorf("unknown link down policy %q", args[0])
+			}
+		}
+	case "up":
+		e.Action = ScheduleLinkUp
+		if len(args) != 0 {
+			return e, fmt.Errorf("too many arguments for up")
+		}
+	case "bandwidth":
+		e.Action = ScheduleBandwidth
+		if len(args) != 1 {
+			return e, fmt.Errorf("expected one argument for bandwidth")
+		}
+		e.Bandwidth, err = ParseBandwidth(args[0])
+		if err != nil {
+			return e, err
+		}
+	default:
+		return e, fmt.Errorf("unknown action %q", fields[2])
+	}
+	return e, nil
+}
+
+// ParseBandwidth parses a bandwidth in bits per second. The value may have
+// one of the suffixes k, M, G or T.
+func ParseBandwidth(s string) (int64, error) {
+	mult := int64(1)
+	switch {
+	case strings.HasSuffix(s, "k"):
+		mult = 1000
+	case strings.HasSuffix(s, "M"):
+		mult = 1000 * 1000
+	case strings.HasSuffix(s, "G"):
+		mult = 1000 * 1000 * 1000
+	case strings.HasSuffix(s, "T"):
+		mult = 1000 * 1000 * 1000 * 1000
+	}
+	if mult != 1 {
+		s = s[:len(s)-1]
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid bandwidth %q", s)
+	}
+	bw := int64(v * float64(mult))
+	if bw <= 0 {
+		return 0, fmt.Errorf("bandwidth must be positive")
+	}
+	return bw, nil
+}
+
+// Attach schedules the events of the port t.Name. Events that are not in
+// the future are applied immediately and in the order of the schedule, so
+// that a port that is created late ends up in the state that the earlier
+// events left it in.
+func (s *Schedule) Attach(w *World, t *Transmitter) {
+	for _, e := range s.Events {
+		if e.Port != "*" && e.Port != t.Name {
+			continue
+		}
+		e := e
+		apply := func(w *World) {
+			switch e.Action {
+			case ScheduleLinkDown:
+				t.Policy = e.Policy
+				t.SetLinkUp(w, false)
+			case ScheduleLinkUp:
+				t.SetLinkUp(w, true)
+			case ScheduleBandwidth:
+				t.SetBandwidth(e.Bandwidth)
+			}
+		}
+		if e.Time <= w.Time() {
+			apply(w)
+			continue
+		}
+		w.At(e.Time, PrioInput, RunnerFunc(apply))
+	}
+}
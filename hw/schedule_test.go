@@ -0,0 +1,92 @@
+package hw
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	s, err := ParseSchedule(strings.NewReader(`
+# time port action
+10s    2001:db8::1  down hold
+10.5s  2001:db8::1  up
+1m     *            bandwidth 2.5G
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []ScheduleEvent{
+		{Time: 10 * time.Second, Port: "2001:db8::1", Action: ScheduleLinkDown, Policy: LinkDownHold},
+		{Time: 10500 * time.Millisecond, Port: "2001:db8::1", Action: ScheduleLinkUp},
+		{Time: time.Minute, Port: "*", Action: ScheduleBandwidth, Bandwidth: 2500 * 1000 * 1000},
+	}
+	if len(s.Events) != len(expected) {
+		t.Fatalf("got %d events, want %d", len(s.Events), len(expected))
+	}
+	for i, e := range expected {
+		if s.Events[i] != e {
+			t.Errorf("event %d: got %+v, want %+v", i, s.Events[i], e)
+		}
+	}
+}
+
+func TestParseScheduleError(t *testing.T) {
+	_, err := ParseSchedule(strings.NewReader("10s port flap\n"))
+	if err == nil {
+		t.Fatal("expected error for unknown action")
+	}
+}
+
+func TestScheduleAttachLate(t *testing.T) {
+	s, err := ParseSchedule(strings.NewReader(`
+1ms  port1  down
+2ms  port1  up
+3ms  port1  bandwidth 1G
+4ms  port1  bandwidth 2G
+6ms  port1  down
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := &World{}
+	w.time = 5 * time.Millisecond
+	tr := NewTransmitter(10*1000*1000*1000, NullHandler{})
+	tr.Name = "port1"
+	s.Attach(w, tr)
+	if !tr.LinkUp() || tr.Bandwidth != 2*1000*1000*1000 {
+		t.Errorf("got link up %v at %d bit/s after the past events, want up at 2G", tr.LinkUp(), tr.Bandwidth)
+	}
+
+	w.Simulate()
+	if tr.LinkUp() {
+		t.Errorf("link is up after the future event, want down")
+	}
+}
+
+func TestScheduleAttachBeforeStart(t *testing.T) {
+	s, err := ParseSchedule(strings.NewReader("1ms port1 down\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Ports of a bridge are created before the receivers set the start
+	// time of the world.
+	w := &World{}
+	tr := NewTransmitter(10*1000*1000*1000, NullHandler{})
+	tr.Name = "port1"
+	s.Attach(w, tr)
+
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	w.AtStart(start, RunnerFunc(func(w *World) {}))
+	w.AtStart(start.Add(-time.Millisecond), RunnerFunc(func(w *World) {}))
+	w.Simulate()
+	if tr.LinkUp() {
+		t.Errorf("link is up, want down")
+	}
+	if w.Time() != 2*time.Millisecond || !w.StartTime().Equal(start.Add(-time.Millisecond)) {
+		t.Errorf("got the last event at %s after %s, want at 2ms", w.Time(), w.StartTime())
+	}
+}
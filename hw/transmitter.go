@@ -17,44 +17,151 @@ func OnWireDuration(p *Packet, bandwidth int64) time.Duration {
 	return time.Duration(bits*int64(time.Second)/bandwidth + 1)
 }
 
+// LinkDownPolicy defines what a Transmitter does with its queue while its
+// link is down.
+type LinkDownPolicy int
+
+const (
+	// LinkDownDrop drops queued packets and packets that arrive while the
+	// link is down.
+	LinkDownDrop LinkDownPolicy = iota
+
+	// LinkDownHold keeps packets queued until the link is up again. The
+	// packet that is on the wire when the link goes down is lost all the
+	// same: it cannot be completed on a dead link, and resending it from
+	// the start would be a retransmission, which Ethernet does not do.
+	LinkDownHold
+)
+
 type Transmitter struct {
+	Name      string
 	Bandwidth int64
 	Output    Handler
 
+	// Policy is applied when the link goes down. Drop receives the dropped
+	// packets.
+	Policy LinkDownPolicy
+	Drop   DropHandler
+
 	buffer []*Packet
+	bytes  int
+	down   bool
+	slot   *transmitterSlot
+}
+
+// transmitterSlot is the event that fires when the packet at the head of
+// the buffer is sent. A slot is abandoned when the link goes down in the
+// middle of a transmission, so that the already scheduled event is ignored.
+type transmitterSlot struct {
+	t       *Transmitter
+	busy    bool
+	aborted bool
 }
 
 func NewTransmitter(bandwidth int64, output Handler) *Transmitter {
-	return &Transmitter{
+	t := &Transmitter{
 		Bandwidth: bandwidth,
 		Output:    output,
+		Drop:      NullDropHandler{},
 		buffer:    make([]*Packet, 0, 128),
 	}
+	t.slot = &transmitterSlot{t: t}
+	return t
 }
 
 func (t *Transmitter) HandlePacket(w *World, p *Packet) {
 	// log.Println("received at", now)
 
+	if t.down && t.Policy == LinkDownDrop {
+		t.drop(w, p)
+		return
+	}
+
 	t.buffer = append(t.buffer, p)
-	if len(t.buffer) == 1 {
-		sentAt := w.Time() + OnWireDuration(t.buffer[0], t.Bandwidth)
-		w.At(sentAt, PrioOutput, t)
+	t.bytes += p.Length
+	t.startSending(w)
+}
+
+// LinkUp reports whether the link of the transmitter is up.
+func (t *Transmitter) LinkUp() bool {
+	return !t.down
+}
+
+// SetLinkUp brings the link up or down. When the link goes down, the packet
+// that is being sent is lost regardless of Policy, and queued packets are
+// handled according to Policy.
+func (t *Transmitter) SetLinkUp(w *World, up bool) {
+	if up == !t.down {
+		return
+	}
+	t.down = !up
+
+	if up {
+		t.startSending(w)
+		return
+	}
+
+	if t.slot.busy {
+		t.slot.aborted = true
+		t.slot = &transmitterSlot{t: t}
+		t.drop(w, t.shift())
+	}
+	if t.Policy == LinkDownDrop {
+		for len(t.buffer) != 0 {
+			t.drop(w, t.shift())
+		}
 	}
 }
 
-func (t *Transmitter) packetSent(w *World) {
-	// log.Println("sent at", now)
+// SetBandwidth changes the bandwidth of the link. The packet that is being
+// sent is not affected.
+func (t *Transmitter) SetBandwidth(bandwidth int64) {
+	t.Bandwidth = bandwidth
+}
+
+// QueuedBytes returns the number of bytes waiting for transmission,
+// including the packet that is being sent.
+func (t *Transmitter) QueuedBytes() int {
+	return t.bytes
+}
+
+func (t *Transmitter) drop(w *World, p *Packet) {
+	t.Drop.HandleDrop(w, p, Drop{
+		Reason:     "link down",
+		Queue:      t.Name,
+		QueueBytes: t.bytes,
+	})
+}
 
+func (t *Transmitter) shift() *Packet {
 	p := t.buffer[0]
 	copy(t.buffer, t.buffer[1:])
 	t.buffer = t.buffer[:len(t.buffer)-1]
-	if len(t.buffer) != 0 {
-		sentAt := w.Time() + OnWireDuration(t.buffer[0], t.Bandwidth)
-		w.At(sentAt, PrioOutput, t)
+	t.bytes -= p.Length
+	return p
+}
+
+func (t *Transmitter) startSending(w *World) {
+	if t.down || t.slot.busy || len(t.buffer) == 0 {
+		return
 	}
+	t.slot.busy = true
+	sentAt := w.Time() + OnWireDuration(t.buffer[0], t.Bandwidth)
+	w.At(sentAt, PrioOutput, t.slot)
+}
+
+func (t *Transmitter) packetSent(w *World) {
+	// log.Println("sent at", now)
+
+	p := t.shift()
+	t.startSending(w)
 	t.Output.HandlePacket(w, p)
 }
 
-func (t *Transmitter) Run(w *World) {
-	t.packetSent(w)
+func (s *transmitterSlot) Run(w *World) {
+	if s.aborted {
+		return
+	}
+	s.busy = false
+	s.t.packetSent(w)
 }
@@ -2,6 +2,7 @@ package hw
 
 import (
 	"log"
+	"math/rand"
 	"time"
 )
 
@@ -95,6 +96,36 @@ type World struct {
 	start time.Time
 	time  time.Duration
 	queue worldEventsQueue
+
+	seed      int64
+	rand      *rand.Rand
+	cartridge *Cartridge
+}
+
+// SetSeed sets the seed of the random number generator returned by Rand.
+func (w *World) SetSeed(seed int64) {
+	w.seed = seed
+	w.rand = nil
+}
+
+// Rand returns the random number generator of the world. All randomness in
+// the model should come from it, so that runs with the same seed are
+// reproducible.
+func (w *World) Rand() *rand.Rand {
+	if w.rand == nil {
+		w.rand = rand.New(rand.NewSource(w.seed))
+	}
+	return w.rand
+}
+
+// ClonePacket returns a copy of p that can be handled independently of p.
+func (w *World) ClonePacket(p *Packet) *Packet {
+	c := newPacket(&w.cartridge)
+	c.CapturedData = append(c.CapturedData, p.CapturedData...)
+	c.Timestamp = p.Timestamp
+	c.Length = p.Length
+	c.InPort = p.InPort
+	return c
 }
 
 func (w *World) At(t time.Duration, prio worldPrio, r Runner) {
@@ -105,8 +136,13 @@ func (w *World) At(t time.Duration, prio worldPrio, r Runner) {
 	})
 }
 
+// AtStart schedules r at the time t, which becomes the start time of the
+// world if it is earlier than the current one. Events that were scheduled
+// before the start time was known are relative to t.
 func (w *World) AtStart(t time.Time, r Runner) {
-	if w.start.IsZero() || t.Before(w.start) {
+	if w.start.IsZero() {
+		w.start = t
+	} else if t.Before(w.start) {
 		offset := w.start.Sub(t)
 		w.start = t
 		for i := range w.queue {
@@ -0,0 +1,116 @@
+package stat
+
+import (
+	"log"
+	"time"
+
+	"github.com/dmage/switchemu/hw"
+)
+
+// BufferStatistics tracks the occupancy of a buffer that packets enter
+// through PortInput and leave through PortOutput or PortDrop. If
+// BufferLimit is set, packets that do not fit into the buffer are passed to
+// Drop.
+type BufferStatistics struct {
+	prev          time.Duration
+	bufferedBytes int
+	nextBucketAt  time.Duration
+
+	ByTime    Int64Buckets
+	Histogram FastInt64Counter
+
+	BufferLimit int
+
+	Name string
+	Drop hw.DropHandler
+
+	// Dropped and DroppedBytes count the packets that did not fit into
+	// the buffer.
+	Dropped      int64
+	DroppedBytes int64
+
+	dropCount     int64
+	nextDropReset time.Duration
+}
+
+func NewBufferStatistics(name string, precision time.Duration) *BufferStatistics {
+	return &BufferStatistics{
+		Name:      name,
+		ByTime:    NewInt64Buckets(precision),
+		Histogram: NewFastInt64Counter(100000),
+	}
+}
+
+func (s *BufferStatistics) updateHistogram(now time.Duration) {
+	delta := now - s.prev
+	s.Histogram.Add(int64(s.bufferedBytes), int64(delta))
+	s.prev = now
+}
+
+func (s *BufferStatistics) updateByTime(now time.Duration) {
+	b := s.ByTime.Get(now)
+	if int64(s.bufferedBytes) > b.Value {
+		b.Value = int64(s.bufferedBytes)
+	}
+}
+
+func (s *BufferStatistics) PortInput(h hw.Handler) hw.Handler {
+	return hw.HandlerFunc(func(w *hw.World, p *hw.Packet) {
+		now := w.Time()
+		if s.BufferLimit > 0 && s.bufferedBytes+p.Length > s.BufferLimit {
+			for now >= s.nextDropReset {
+				if s.dropCount != 0 {
+					log.Println(w.StartTime().Local().Add(s.nextDropReset), s.Name, "dropped", s.dropCount/60)
+					s.dropCount = 0
+				}
+				s.nextDropReset += 60 * time.Second
+			}
+			s.dropCount++
+			s.Dropped++
+			s.DroppedBytes += int64(p.Length)
+			if s.Drop != nil {
+				s.Drop.HandleDrop(w, p, hw.Drop{
+					Reason:     "buffer limit",
+					Queue:      s.Name,
+					QueueBytes: s.bufferedBytes,
+				})
+			}
+			return
+		}
+		s.updateHistogram(now)
+		s.bufferedBytes += p.Length
+		s.updateByTime(now)
+		h.HandlePacket(w, p)
+	})
+}
+
+func (s *BufferStatistics) PortOutput(h hw.Handler) hw.Handler {
+	return hw.HandlerFunc(func(w *hw.World, p *hw.Packet) {
+		now := w.Time()
+		s.updateHistogram(now)
+		s.bufferedBytes -= p.Length
+		s.updateByTime(now)
+		h.HandlePacket(w, p)
+	})
+}
+
+// Resize accounts a change of the length of a packet that is in the
+// buffer, see hw.LabelSwitch.OnResize.
+func (s *BufferStatistics) Resize(w *hw.World, p *hw.Packet, delta int) {
+	now := w.Time()
+	s.updateHistogram(now)
+	s.bufferedBytes += delta
+	s.updateByTime(now)
+}
+
+// PortDrop accounts packets that are dropped after they have been admitted
+// to the buffer.
+func (s *BufferStatistics) PortDrop(h hw.DropHandler) hw.DropHandler {
+	return hw.DropHandlerFunc(func(w *hw.World, p *hw.Packet, d hw.Drop) {
+		now := w.Time()
+		s.updateHistogram(now)
+		s.bufferedBytes -= p.Length
+		s.updateByTime(now)
+		h.HandleDrop(w, p, d)
+	})
+}
@@ -0,0 +1,54 @@
+package stat
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dmage/switchemu/hw"
+)
+
+func TestBufferStatisticsLabelPop(t *testing.T) {
+	table, err := hw.ParseLFIB(strings.NewReader(`
+100      swap 200   core1
+101      pop        edge1
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := &hw.World{}
+	s := NewBufferStatistics("total", time.Millisecond)
+	departed := 0
+	output := func(name string) hw.Handler {
+		return s.PortOutput(hw.HandlerFunc(func(w *hw.World, p *hw.Packet) {
+			departed++
+		}))
+	}
+	labelSwitch := hw.NewLabelSwitch(table, output, hw.NullHandler{})
+	labelSwitch.OnResize = s.Resize
+	input := s.PortInput(labelSwitch)
+
+	labeled := func(label uint32) *hw.Packet {
+		data := make([]byte, 14+4+20)
+		binary.BigEndian.PutUint16(data[12:], hw.EtherTypeMPLS)
+		binary.BigEndian.PutUint32(data[14:], label<<12|0x100|64)
+		data[18] = 0x45
+		return &hw.Packet{CapturedData: data, Length: len(data)}
+	}
+	for i, label := range []uint32{100, 101, 101} {
+		p := labeled(label)
+		w.At(time.Duration(i)*time.Microsecond, hw.PrioInput, hw.RunnerFunc(func(w *hw.World) {
+			input.HandlePacket(w, p)
+		}))
+	}
+	w.Simulate()
+
+	if departed != 3 {
+		t.Fatalf("got %d departed packets, want 3", departed)
+	}
+	if s.bufferedBytes != 0 {
+		t.Errorf("got %d buffered bytes after all packets have left, want 0", s.bufferedBytes)
+	}
+}
@@ -0,0 +1,62 @@
+package stat
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/dmage/switchemu/hw"
+)
+
+// ExtHeaderCounter counts IPv6 packets with each type of extension header,
+// and fragments of IPv4 and IPv6 packets. A packet is counted once for
+// every type of extension header it carries.
+type ExtHeaderCounter struct {
+	IPv6              int64
+	ExtHeaders        [len(extHeaderBits)]int64
+	Fragments         int64
+	NonFirstFragments int64
+
+	output  hw.Handler
+	headers hw.Headers
+}
+
+var extHeaderBits = [...]uint8{hw.ExtHopByHop, hw.ExtRouting, hw.ExtFragment, hw.ExtDestOpts, hw.ExtAH, hw.ExtESP, hw.ExtMobility}
+
+func NewExtHeaderCounter(output hw.Handler) *ExtHeaderCounter {
+	return &ExtHeaderCounter{
+		output: output,
+	}
+}
+
+func (s *ExtHeaderCounter) HandlePacket(w *hw.World, p *hw.Packet) {
+	if s.headers.Parse(p.CapturedData) {
+		h := &s.headers
+		if h.EtherType == hw.EtherTypeIPv6 {
+			s.IPv6++
+			for i, bit := range extHeaderBits {
+				if h.ExtHeaders&bit != 0 {
+					s.ExtHeaders[i]++
+				}
+			}
+		}
+		if h.Fragment {
+			s.Fragments++
+			if h.FragmentOffset != 0 {
+				s.NonFirstFragments++
+			}
+		}
+	}
+	s.output.HandlePacket(w, p)
+}
+
+func (s *ExtHeaderCounter) Dump(filename string) error {
+	return CreateFile(filename, func(w io.Writer) error {
+		fmt.Fprintf(w, "ipv6\t%d\n", s.IPv6)
+		for i, n := range s.ExtHeaders {
+			fmt.Fprintf(w, "%s\t%d\n", hw.ExtHeaderNames[i], n)
+		}
+		fmt.Fprintf(w, "fragments\t%d\n", s.Fragments)
+		fmt.Fprintf(w, "non_first_fragments\t%d\n", s.NonFirstFragments)
+		return nil
+	})
+}
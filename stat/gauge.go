@@ -0,0 +1,70 @@
+package stat
+
+import (
+	"time"
+
+	"github.com/dmage/switchemu/hw"
+)
+
+// Gauge records the maximum of a sampled value per time interval. The value
+// is sampled whenever a packet passes through a handler returned by Sample.
+type Gauge struct {
+	value   func() int64
+	buckets Int64Buckets
+}
+
+func NewGauge(interval time.Duration, value func() int64) *Gauge {
+	return &Gauge{
+		value:   value,
+		buckets: NewInt64Buckets(interval),
+	}
+}
+
+// Sample returns a handler that passes packets to h and samples the value
+// afterwards.
+func (s *Gauge) Sample(h hw.Handler) hw.Handler {
+	return hw.HandlerFunc(func(w *hw.World, p *hw.Packet) {
+		h.HandlePacket(w, p)
+
+		b := s.buckets.Get(w.Time())
+		if v := s.value(); v > b.Value {
+			b.Value = v
+		}
+	})
+}
+
+func (s *Gauge) Dump(w *hw.World, filename string) error {
+	return s.buckets.Dump(w.StartTime(), filename)
+}
+
+// Delta records how much a monotonically increasing value, such as a byte
+// counter, grows per time interval. The value is sampled whenever a packet
+// passes through a handler returned by Sample.
+type Delta struct {
+	value   func() int64
+	last    int64
+	buckets Int64Buckets
+}
+
+func NewDelta(interval time.Duration, value func() int64) *Delta {
+	return &Delta{
+		value:   value,
+		buckets: NewInt64Buckets(interval),
+	}
+}
+
+// Sample returns a handler that passes packets to h and samples the value
+// afterwards.
+func (s *Delta) Sample(h hw.Handler) hw.Handler {
+	return hw.HandlerFunc(func(w *hw.World, p *hw.Packet) {
+		h.HandlePacket(w, p)
+
+		v := s.value()
+		s.buckets.Get(w.Time()).Value += v - s.last
+		s.last = v
+	})
+}
+
+func (s *Delta) Dump(w *hw.World, filename string) error {
+	return s.buckets.Dump(w.StartTime(), filename)
+}
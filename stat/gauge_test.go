@@ -0,0 +1,29 @@
+package stat
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dmage/switchemu/hw"
+)
+
+func TestDelta(t *testing.T) {
+	w := &hw.World{}
+	var counter int64
+	d := NewDelta(10*time.Millisecond, func() int64 {
+		return counter
+	})
+	h := d.Sample(hw.HandlerFunc(func(w *hw.World, p *hw.Packet) {
+		counter += int64(p.Length)
+	}))
+	for _, at := range []time.Duration{1, 2, 12} {
+		w.At(at*time.Millisecond, hw.PrioInput, hw.RunnerFunc(func(w *hw.World) {
+			h.HandlePacket(w, &hw.Packet{Length: 100})
+		}))
+	}
+	w.Simulate()
+
+	if len(d.buckets.buckets) != 2 || d.buckets.buckets[0].Value != 200 || d.buckets.buckets[1].Value != 100 {
+		t.Errorf("got buckets %+v, want 200 and 100 bytes", d.buckets.buckets)
+	}
+}
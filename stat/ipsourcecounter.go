@@ -0,0 +1,50 @@
+package stat
+
+import (
+	"time"
+
+	"github.com/dmage/switchemu/hw"
+)
+
+// IPSourceCounter counts distinct IPv4 and IPv6 source addresses per time
+// interval. If Decapsulate is set, the inner source addresses of tunnel
+// packets are counted.
+type IPSourceCounter struct {
+	Decapsulate bool
+
+	prevBucket *TimeInt64
+	sources    map[string]struct{}
+	buckets    Int64Buckets
+	output     hw.Handler
+	headers    hw.Headers
+}
+
+func NewIPSourceCounter(interval time.Duration, output hw.Handler) *IPSourceCounter {
+	return &IPSourceCounter{
+		buckets: NewInt64Buckets(interval),
+		output:  output,
+	}
+}
+
+func (s *IPSourceCounter) HandlePacket(w *hw.World, p *hw.Packet) {
+	ok := s.headers.ParseFor(p.CapturedData, s.Decapsulate)
+	if ok {
+		b := s.buckets.Get(w.Time())
+		if b != s.prevBucket {
+			s.prevBucket = b
+			s.sources = make(map[string]struct{})
+		}
+
+		srcIP := s.headers.SrcIP
+		_, ok := s.sources[string(srcIP)]
+		if !ok {
+			b.Value += 1
+			s.sources[string(srcIP)] = struct{}{}
+		}
+	}
+	s.output.HandlePacket(w, p)
+}
+
+func (s *IPSourceCounter) Dump(w *hw.World, filename string) error {
+	return s.buckets.Dump(w.StartTime(), filename)
+}
@@ -0,0 +1,59 @@
+package stat
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/dmage/switchemu/hw"
+)
+
+// MemberLoad counts packets and bytes that are sent to each member of a
+// group of ports (an ECMP group or a link aggregation group).
+type MemberLoad struct {
+	Packets []int64
+	Bytes   []int64
+}
+
+func NewMemberLoad(members int) *MemberLoad {
+	return &MemberLoad{
+		Packets: make([]int64, members),
+		Bytes:   make([]int64, members),
+	}
+}
+
+// Member returns a handler that accounts packets for the member i and
+// passes them to output.
+func (l *MemberLoad) Member(i int, output hw.Handler) hw.Handler {
+	return hw.HandlerFunc(func(w *hw.World, p *hw.Packet) {
+		l.Packets[i]++
+		l.Bytes[i] += int64(p.Length)
+		output.HandlePacket(w, p)
+	})
+}
+
+// Imbalance returns the ratio of the bytes sent to the most loaded member
+// to the average number of bytes per member. A perfectly balanced group has
+// an imbalance of 1.
+func (l *MemberLoad) Imbalance() float64 {
+	var total, max int64
+	for _, b := range l.Bytes {
+		total += b
+		if b > max {
+			max = b
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(max) * float64(len(l.Bytes)) / float64(total)
+}
+
+func (l *MemberLoad) Dump(filename string) error {
+	return CreateFile(filename, func(w io.Writer) error {
+		for i := range l.Packets {
+			fmt.Fprintf(w, "%d\t%d\t%d\n", i, l.Packets[i], l.Bytes[i])
+		}
+		fmt.Fprintf(w, "imbalance\t%.6f\n", l.Imbalance())
+		return nil
+	})
+}
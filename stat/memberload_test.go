@@ -0,0 +1,38 @@
+package stat
+
+import (
+	"math"
+	"testing"
+
+	"github.com/dmage/switchemu/hw"
+)
+
+func TestMemberLoad(t *testing.T) {
+	testCases := []struct {
+		bytes     []int
+		imbalance float64
+	}{
+		{[]int{100, 100, 100, 100}, 1},
+		{[]int{400, 0, 0, 0}, 4},
+		{[]int{300, 100}, 1.5},
+		{[]int{0, 0}, 0},
+	}
+	for _, tc := range testCases {
+		w := &hw.World{}
+		l := NewMemberLoad(len(tc.bytes))
+		for i, n := range tc.bytes {
+			h := l.Member(i, hw.HandlerFunc(func(w *hw.World, p *hw.Packet) {}))
+			if n != 0 {
+				h.HandlePacket(w, &hw.Packet{Length: n})
+			}
+		}
+		for i, n := range tc.bytes {
+			if want := int64(n); l.Bytes[i] != want {
+				t.Errorf("%v: member %d: got %d bytes, want %d", tc.bytes, i, l.Bytes[i], want)
+			}
+		}
+		if got := l.Imbalance(); math.Abs(got-tc.imbalance) > 1e-9 {
+			t.Errorf("%v: got imbalance %f, want %f", tc.bytes, got, tc.imbalance)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package stat
+
+import (
+	"time"
+
+	"github.com/dmage/switchemu/hw"
+)
+
+// QueueStatistics tracks the occupancy of a queue that reports its size
+// explicitly (as opposed to being wrapped around a handler chain).
+type QueueStatistics struct {
+	prev  time.Duration
+	bytes int
+
+	ByTime    Int64Buckets
+	Histogram FastInt64Counter
+}
+
+func NewQueueStatistics(precision time.Duration) *QueueStatistics {
+	return &QueueStatistics{
+		ByTime:    NewInt64Buckets(precision),
+		Histogram: NewFastInt64Counter(100000),
+	}
+}
+
+func (s *QueueStatistics) Set(now time.Duration, bytes int) {
+	s.Histogram.Add(int64(s.bytes), int64(now-s.prev))
+	s.prev = now
+	s.bytes = bytes
+
+	b := s.ByTime.Get(now)
+	if int64(s.bytes) > b.Value {
+		b.Value = int64(s.bytes)
+	}
+}
+
+func (s *QueueStatistics) Dump(w *hw.World, byTimeFilename, histogramFilename string) error {
+	err := s.ByTime.Dump(w.StartTime(), byTimeFilename)
+	if err != nil {
+		return err
+	}
+	return s.Histogram.Dump(histogramFilename)
+}
@@ -0,0 +1,94 @@
+package stat
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/dmage/switchemu/hw"
+)
+
+// ReorderCounter counts packets that leave out of order with respect to
+// earlier packets of the same flow. The order is defined by the capture
+// timestamps of the packets, so the counter should be placed after the
+// element that may reorder packets.
+//
+// Like the flowlet table, the counter forgets flows that have been idle
+// for IdleTimeout, so its memory is bounded by the number of active flows.
+// A packet that is overtaken by a packet sent more than IdleTimeout later
+// is not counted.
+type ReorderCounter struct {
+	Packets     int64
+	Reordered   int64
+	IdleTimeout time.Duration
+
+	output    hw.Handler
+	flows     map[string]reorderFlow
+	lastSweep time.Duration
+	headers   hw.Headers
+	key       []byte
+}
+
+type reorderFlow struct {
+	last time.Time     // the latest capture timestamp
+	seen time.Duration // the world time of the latest packet
+}
+
+func NewReorderCounter(idleTimeout time.Duration, output hw.Handler) *ReorderCounter {
+	return &ReorderCounter{
+		IdleTimeout: idleTimeout,
+		output:      output,
+		flows:       make(map[string]reorderFlow),
+	}
+}
+
+// Count counts the packet p without passing it on. It can be used to
+// observe packets of one group that leave through several ports.
+func (s *ReorderCounter) Count(w *hw.World, p *hw.Packet) {
+	now := w.Time()
+	if now-s.lastSweep > s.IdleTimeout {
+		for key, f := range s.flows {
+			if now-f.seen > s.IdleTimeout {
+				delete(s.flows, key)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	if !s.headers.Parse(p.CapturedData) {
+		return
+	}
+	h := &s.headers
+	key := append(s.key[:0], h.SrcIP...)
+	key = append(key, h.DstIP...)
+	key = append(key, h.Protocol, byte(h.SrcPort>>8), byte(h.SrcPort), byte(h.DstPort>>8), byte(h.DstPort))
+	s.key = key
+
+	s.Packets++
+	f, ok := s.flows[string(key)]
+	if ok && now-f.seen <= s.IdleTimeout && p.Timestamp.Before(f.last) {
+		s.Reordered++
+		f.seen = now
+	} else {
+		f = reorderFlow{last: p.Timestamp, seen: now}
+	}
+	s.flows[string(key)] = f
+}
+
+// Flows returns the number of flows that the counter remembers.
+func (s *ReorderCounter) Flows() int {
+	return len(s.flows)
+}
+
+func (s *ReorderCounter) HandlePacket(w *hw.World, p *hw.Packet) {
+	s.Count(w, p)
+	s.output.HandlePacket(w, p)
+}
+
+func (s *ReorderCounter) Dump(filename string) error {
+	return CreateFile(filename, func(w io.Writer) error {
+		fmt.Fprintf(w, "packets\t%d\n", s.Packets)
+		fmt.Fprintf(w, "reordered\t%d\n", s.Reordered)
+		return nil
+	})
+}
@@ -0,0 +1,60 @@
+package stat
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dmage/switchemu/hw"
+)
+
+// udpPacket returns a UDP packet from the given source port that was
+// captured at the time ts.
+func udpPacket(sport byte, ts time.Duration) *hw.Packet {
+	data := make([]byte, 14+20+8)
+	data[12], data[13] = 0x08, 0x00
+	ip := data[14:]
+	ip[0] = 0x45
+	ip[9] = hw.ProtocolUDP
+	copy(ip[12:], []byte{192, 0, 2, 1, 192, 0, 2, 2})
+	ip[21] = sport
+	return &hw.Packet{CapturedData: data, Length: len(data), Timestamp: time.Unix(0, 0).Add(ts)}
+}
+
+func TestReorderCounter(t *testing.T) {
+	w := &hw.World{}
+	c := NewReorderCounter(time.Millisecond, nil)
+
+	// Packets of flow 1 leave as 0, 2, 1, flow 2 is in order. Flow 3
+	// leaves its second packet before the first one after it has been
+	// idle for longer than the timeout, which is not counted.
+	departures := []struct {
+		at    time.Duration
+		sport byte
+		ts    time.Duration
+	}{
+		{0, 1, 0},
+		{10 * time.Microsecond, 1, 2},
+		{20 * time.Microsecond, 2, 0},
+		{30 * time.Microsecond, 1, 1},
+		{40 * time.Microsecond, 2, 1},
+		{50 * time.Microsecond, 3, 5},
+		{5 * time.Millisecond, 3, 4},
+	}
+	flows := 0
+	for _, d := range departures {
+		d := d
+		w.At(d.at, hw.PrioOutput, hw.RunnerFunc(func(w *hw.World) {
+			c.Count(w, udpPacket(d.sport, d.ts))
+			flows = c.Flows()
+		}))
+	}
+	w.Simulate()
+
+	if c.Packets != 7 || c.Reordered != 1 {
+		t.Errorf("got %d packets, %d reordered; want 7, 1", c.Packets, c.Reordered)
+	}
+	// Idle flows 1 and 2 have been forgotten.
+	if flows != 1 {
+		t.Errorf("got %d flows, want 1", flows)
+	}
+}
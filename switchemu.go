@@ -4,96 +4,270 @@ import (
 	"bufio"
 	"flag"
 	"fmt"
+	"io"
 	"log"
-	"net"
 	"os"
 	"runtime"
 	"runtime/pprof"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
 	"github.com/google/gopacket/pcapgo"
 
+	"github.com/dmage/switchemu/filter"
+	"github.com/dmage/switchemu/gen"
 	"github.com/dmage/switchemu/hw"
 	"github.com/dmage/switchemu/stat"
+	"github.com/dmage/switchemu/topo"
 )
 
 const (
 	readerBufferSize = 4 << 20
-)
 
-type BufferStatistics struct {
-	prev          time.Duration
-	bufferedBytes int
-	nextBucketAt  time.Duration
+	// reorderIdleTimeout is how long reorder counters remember idle flows.
+	reorderIdleTimeout = time.Second
+
+	// lagHashSalt is the salt of the LAG hash, see FlowHash.Salt.
+	lagHashSalt = 0x4c414731
 
-	ByTime    stat.Int64Buckets
-	Histogram stat.FastInt64Counter
+	// portBandwidth is the bandwidth of the egress ports in bits per
+	// second. The crossbar fabric runs at the same rate times its speedup.
+	portBandwidth = 10 * 1000 * 1000 * 1000
+)
 
-	BufferLimit int
+// peekedSource is an input source whose first packet has been read ahead.
+type peekedSource struct {
+	gen.Source
+	data   []byte
+	ci     gopacket.CaptureInfo
+	err    error
+	peeked bool
 }
 
-func NewBufferStatistics(precision time.Duration) *BufferStatistics {
-	return &BufferStatistics{
-		ByTime:    stat.NewInt64Buckets(precision),
-		Histogram: stat.NewFastInt64Counter(100000),
+func peek(source gen.Source) *peekedSource {
+	data, ci, err := source.ReadPacketData()
+	return &peekedSource{
+		Source: source,
+		data:   append([]byte(nil), data...),
+		ci:     ci,
+		err:    err,
+		peeked: true,
 	}
 }
 
-func (s *BufferStatistics) updateHistogram(now time.Duration) {
-	delta := now - s.prev
-	s.Histogram.Add(int64(s.bufferedBytes), int64(delta))
-	s.prev = now
+func (s *peekedSource) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	if s.peeked {
+		s.peeked = false
+		return s.data, s.ci, s.err
+	}
+	return s.Source.ReadPacketData()
 }
 
-func (s *BufferStatistics) updateByTime(now time.Duration) {
-	b := s.ByTime.Get(now)
-	if int64(s.bufferedBytes) > b.Value {
-		b.Value = int64(s.bufferedBytes)
-	}
+// stringList is a flag that may be given several times.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, "; ")
 }
 
-var dropCount int64
-var lastDrop time.Duration
-var nextDropReset time.Duration
-
-func (s *BufferStatistics) PortInput(h hw.Handler) hw.Handler {
-	return hw.HandlerFunc(func(w *hw.World, p *hw.Packet) {
-		now := w.Time()
-		if s.BufferLimit > 0 && s.bufferedBytes+p.Length > s.BufferLimit {
-			for now >= nextDropReset {
-				if dropCount != 0 {
-					log.Println(w.StartTime().Local().Add(nextDropReset), "dropped", dropCount/60)
-					dropCount = 0
-				}
-				nextDropReset += 60 * time.Second
-			}
-			dropCount++
-			return
-		}
-		s.updateHistogram(now)
-		s.bufferedBytes += p.Length
-		s.updateByTime(now)
-		h.HandlePacket(w, p)
-	})
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
 }
 
-func (s *BufferStatistics) PortOutput(h hw.Handler) hw.Handler {
-	return hw.HandlerFunc(func(w *hw.World, p *hw.Packet) {
-		now := w.Time()
-		s.updateHistogram(now)
-		s.bufferedBytes -= 1 //p.Length
-		s.updateByTime(now)
-		h.HandlePacket(w, p)
-	})
+// egressPort is an egress port of the switch. Packets handled by input
+// are sent over link, a transmitter or a LAG. The functions in departed
+// observe the packets that leave the port.
+type egressPort struct {
+	input    hw.Handler
+	link     hw.Member
+	departed []func(w *hw.World, p *hw.Packet)
+}
+
+type lagSpec struct {
+	Members   int
+	Bandwidth int64
+}
+
+// parseLAGs parses a list of link aggregation groups in the form
+// name=<members>x<bandwidth>[,...].
+func parseLAGs(s string) (map[string]lagSpec, error) {
+	lags := make(map[string]lagSpec)
+	if s == "" {
+		return lags, nil
+	}
+	for _, item := range strings.Split(s, ",") {
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid lag %q: expected name=<members>x<bandwidth>", item)
+		}
+		spec := strings.SplitN(parts[1], "x", 2)
+		if len(spec) != 2 {
+			return nil, fmt.Errorf("invalid lag %q: expected name=<members>x<bandwidth>", item)
+		}
+		members, err := strconv.Atoi(spec[0])
+		if err != nil || members < 1 {
+			return nil, fmt.Errorf("invalid number of lag members %q", spec[0])
+		}
+		bandwidth, err := hw.ParseBandwidth(spec[1])
+		if err != nil {
+			return nil, err
+		}
+		lags[parts[0]] = lagSpec{
+			Members:   members,
+			Bandwidth: bandwidth,
+		}
+	}
+	return lags, nil
 }
 
 var cpuprofile = flag.String("cpuprofile", "", "write cpu profile `file`")
 var memprofile = flag.String("memprofile", "", "write memory profile to `file`")
 var blockprofile = flag.String("blockprofile", "", "write block profile to `file`")
 
+var egressPcap = flag.String("egress-pcap", "", "write departing packets of every egress port to a capture file of the given `format` (pcap or pcapng)")
+
+var bufferLimit = flag.Int("buffer-limit", 0, "drop packets when the switch buffers more than `bytes` (0 means unlimited)")
+var dropPcap = flag.String("drop-pcap", "", "write dropped packets to a pcapng `file`")
+var dropSample = flag.Int("drop-sample", 1, "write only every `n`th dropped packet")
+
+var timeScale = flag.Float64("time-scale", 1, "divide the inter-arrival times of input files by `factor`, e.g. 2 replays them at twice the load")
+var multiplyFlows = flag.Int("multiply-flows", 1, "replay every flow of the input files `n` times with rewritten source addresses")
+var multiplyOffset = flag.Duration("multiply-offset", 100*time.Microsecond, "maximum random time `offset` of the copies of multiplied flows")
+
+var seed = flag.Int64("seed", 0, "`seed` of the random number generator")
+var impair = flag.String("impair", "", "impair input traffic according to a netem-like `spec`, e.g. \"loss 1% reorder 2% 100us\"")
+
+var inputFilter = flag.String("filter", "", "read only packets that match the filter `expression` from input files, e.g. \"tcp port 443\"")
+var classify = flag.String("classify", "", "forward only packets that match the filter `expression` at the switch ingress; the others are dropped or sent to -classify-port")
+var classifyPort = flag.String("classify-port", "", "send packets that do not match -classify to the egress port `name` instead of dropping them")
+
+var aclFlag = flag.String("acl", "", "filter packets by the access-control list in `file`; redirected packets go to the egress port named after the queue")
+
+var replicate = flag.String("replicate", "", "replicate multicast and broadcast packets to the egress ports of the groups in `file`")
+
+var extHeaders = flag.Bool("ext-headers", false, "count the IPv6 extension headers and fragments of input packets")
+
+var decap = flag.Bool("decap", false, "classify and count tunnel packets (VXLAN, Geneve, GRE, IP-in-IP) by their inner headers")
+
+var l2 = flag.Bool("l2", false, "forward frames by a MAC learning bridge; every input file is a bridge port")
+var l2Aging = flag.Duration("l2-aging", 300*time.Second, "MAC address table aging `time`")
+
+var demuxKey = flag.String("demux-key", "dst-ip", "`key` that selects the egress port when no route table is used (dst-ip, src-ip, dst-prefix/N, src-prefix/N, 5-tuple, vlan, dscp, vni, mpls-label, in-port)")
+var demuxMaxKeys = flag.Int("demux-max-keys", 0, "maximum `number` of egress ports created by the demux; other keys share an overflow port (0 means unlimited)")
+
+var routes = flag.String("routes", "", "forward packets to egress ports according to the route table in `file`")
+
+var lfibFlag = flag.String("lfib", "", "forward MPLS packets to egress ports according to the label forwarding table in `file`")
+
+var lagFlag = flag.String("lag", "", "comma-separated list of egress ports that are link aggregation groups, e.g. \"uplink1=4x10G\"")
+
+var lagBalance = flag.String("lag-balance", "hash", "link aggregation balancing `policy` (hash, flowlet, least-queued)")
+var ecmpBalance = flag.String("ecmp-balance", "hash", "ECMP balancing `policy` (hash, flowlet, least-queued)")
+var flowletTimeout = flag.Duration("flowlet-timeout", 500*time.Microsecond, "inactivity `timeout` after which a flowlet may move to another member of a LAG or ECMP group")
+
+var ecmpHash = flag.String("ecmp-hash", "5-tuple", "comma-separated list of header `fields` used for ECMP hashing")
+var ecmpHashFunc = flag.String("ecmp-hash-func", "crc32", "ECMP hash `function` (crc32, crc32c, fnv1a, xor)")
+var ecmpSeed = flag.Uint("ecmp-seed", 0, "ECMP hash `seed`")
+
+var lagHash = flag.String("lag-hash", "5-tuple", "comma-separated list of header `fields` used for LAG hashing")
+var lagHashFunc = flag.String("lag-hash-func", "crc32", "LAG hash `function` (crc32, crc32c, fnv1a, xor)")
+var lagSeed = flag.Uint("lag-seed", 1, "LAG hash `seed`")
+
+var schedule = flag.String("schedule", "", "read link failures and bandwidth changes for egress ports from `file`")
+
+var topology = flag.String("topology", "", "simulate the network of switches described in `file` instead of a single switch; the switches are configured by the file, only the input, drop, schedule and profiling options may be combined with it")
+
+// topologyFlags are the flags that may be used with -topology. The other
+// flags configure the single switch and are rejected.
+var topologyFlags = map[string]bool{
+	"cpuprofile":      true,
+	"memprofile":      true,
+	"blockprofile":    true,
+	"drop-pcap":       true,
+	"drop-sample":     true,
+	"time-scale":      true,
+	"multiply-flows":  true,
+	"multiply-offset": true,
+	"seed":            true,
+	"filter":          true,
+	"schedule":        true,
+	"generate":        true,
+	"topology":        true,
+}
+
+var crossbar = flag.Bool("crossbar", false, "use an input-queued crossbar fabric instead of output queues only")
+var crossbarSpeedup = flag.Float64("crossbar-speedup", 1, "crossbar fabric `speedup`")
+var crossbarIterations = flag.Int("crossbar-iterations", 1, "number of iSLIP `iterations` per scheduling round")
+var crossbarFIFO = flag.Bool("crossbar-fifo", false, "use a single FIFO per crossbar input instead of virtual output queues")
+
+var generate stringList
+
+func init() {
+	flag.Var(&generate, "generate", "add an input of synthetic traffic described by `spec`, e.g. \"poisson rate=4G size=imix\" or \"incast senders=32 burst=64k\"; may be given several times, the inputs follow the input files and start with the earliest of them")
+}
+
+// openCapture opens a capture file. The file is closed by the caller.
+func openCapture(filename string) (*pcapgo.Reader, *os.File) {
+	f, err := os.Open(filename)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	r := bufio.NewReaderSize(f, readerBufferSize)
+
+	h, err := pcapgo.NewReader(r)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// h, err := pcap.OpenOffline(filename)
+	// if err != nil {
+	//	log.Fatal(err)
+	// }
+	// defer h.Close()
+
+	return h, f
+}
+
+// isFile reports whether name is an existing file.
+func isFile(name string) bool {
+	fi, err := os.Stat(name)
+	return err == nil && !fi.IsDir()
+}
+
 func main() {
+	// An input file named like a subcommand is still an input file.
+	if len(os.Args) > 1 && !isFile(os.Args[1]) {
+		switch os.Args[1] {
+		case "fit":
+			fitCommand(os.Args[2:])
+			return
+		case "gen":
+			genCommand(os.Args[2:])
+			return
+		}
+	}
+
 	flag.Parse()
 
+	if *topology != "" {
+		flag.Visit(func(f *flag.Flag) {
+			if !topologyFlags[f.Name] {
+				log.Fatalf("-%s cannot be used with -topology", f.Name)
+			}
+		})
+	}
+	if *egressPcap != "" && *egressPcap != "pcap" && *egressPcap != "pcapng" {
+		log.Fatalf("unknown egress capture format %q (available: pcap, pcapng)", *egressPcap)
+	}
+	if *classifyPort != "" && *classify == "" {
+		log.Fatal("-classify-port requires -classify")
+	}
+
 	if *cpuprofile != "" {
 		f, err := os.Create(*cpuprofile)
 		if err != nil {
@@ -134,35 +308,126 @@ func main() {
 		}
 	}()
 
-	var sources []*pcapgo.Reader
+	var sources []gen.Source
 	for _, filename := range flag.Args() {
-		f, err := os.Open(filename)
+		h, f := openCapture(filename)
+		defer f.Close()
+		sources = append(sources, h)
+	}
+	inputFiles := len(sources)
+
+	// Generators start with the earliest input file, so that synthetic
+	// traffic can be mixed with captured background traffic. It is also
+	// the origin of time scaling.
+	inputStart := time.Unix(0, 0).UTC()
+	if *timeScale <= 0 {
+		log.Fatal("time-scale must be positive")
+	}
+	if len(generate) != 0 || *timeScale != 1 {
+		found := false
+		for i, source := range sources {
+			p := peek(source)
+			if p.err == nil && (!found || p.ci.Timestamp.Before(inputStart)) {
+				inputStart = p.ci.Timestamp
+				found = true
+			}
+			sources[i] = p
+		}
+	}
+	for _, spec := range generate {
+		g, err := gen.Parse(spec, inputStart)
 		if err != nil {
 			log.Fatal(err)
 		}
-		defer f.Close()
+		sources = append(sources, g)
+	}
+
+	linkType := layers.LinkTypeEthernet
+	if len(sources) != 0 {
+		linkType = sources[0].LinkType()
+	}
 
-		r := bufio.NewReaderSize(f, readerBufferSize)
+	var dumpers []func() error
+	w := &hw.World{}
+	w.SetSeed(*seed)
 
-		h, err := pcapgo.NewReader(r)
+	var portSchedule *hw.Schedule
+	if *schedule != "" {
+		var err error
+		portSchedule, err = hw.LoadSchedule(*schedule)
 		if err != nil {
 			log.Fatal(err)
 		}
+	}
 
-		// h, err := pcap.OpenOffline(filename)
-		// if err != nil {
-		//	log.Fatal(err)
-		// }
-		// defer h.Close()
+	dropSink := hw.DropHandler(hw.NullDropHandler{})
+	if *dropPcap != "" {
+		dropWriter, err := hw.NewDropWriter(*dropPcap, linkType)
+		if err != nil {
+			log.Fatal(err)
+		}
+		dropWriter.SampleRate = *dropSample
+		dumpers = append(dumpers, dropWriter.Close)
+		dropSink = dropWriter
+	}
 
-		sources = append(sources, h)
+	// newPacketSource returns the packets of the input with the given index
+	// that pass the input filter. The packets of input files are scaled.
+	newPacketSource := func(i int, source gen.Source) gopacket.PacketDataSource {
+		var packetSource gopacket.PacketDataSource = source
+		if *inputFilter != "" {
+			f, err := filter.Compile(*inputFilter)
+			if err != nil {
+				log.Fatal(err)
+			}
+			f.Decapsulate = *decap
+			filtered := filter.NewSource(source, f)
+			dumpers = append(dumpers, func() error {
+				return stat.CreateFile(fmt.Sprintf("./output/input.filter.%d.txt", i), func(out io.Writer) error {
+					fmt.Fprintf(out, "matched\t%d\n", filtered.Matched)
+					fmt.Fprintf(out, "filtered\t%d\n", filtered.Filtered)
+					return nil
+				})
+			})
+			packetSource = filtered
+		}
+		if i < inputFiles && *multiplyFlows > 1 {
+			packetSource = gen.NewMultiply(packetSource, *multiplyFlows, *multiplyOffset, *seed+int64(i))
+		}
+		if i < inputFiles && *timeScale != 1 {
+			packetSource = gen.NewCompress(packetSource, *timeScale, inputStart)
+		}
+		return packetSource
 	}
 
-	var dumpers []func() error
-	w := &hw.World{}
+	if *topology != "" {
+		network := topo.NewNetwork(w, dropSink)
+		network.Schedule = portSchedule
+		if err := network.Load(*topology); err != nil {
+			log.Fatal(err)
+		}
+		for i, source := range sources {
+			input := network.Input(i)
+			if input == nil {
+				log.Fatalf("input %d is not attached to the topology", i)
+			}
+			hw.NewReceiver(w, newPacketSource(i, source), source.LinkType(), 40*1000*1000*1000, hw.NewInPortSetter(i, input))
+		}
+		w.Simulate()
+		dump(append(dumpers, network.Dumpers...))
+		return
+	}
 
-	bufferTotal := NewBufferStatistics(100 * time.Microsecond)
-	// bufferTotal.BufferLimit = 1024000
+	bufferTotal := stat.NewBufferStatistics("total", 100*time.Microsecond)
+	bufferTotal.BufferLimit = *bufferLimit
+	bufferTotal.Drop = dropSink
+	dumpers = append(dumpers, func() error {
+		return stat.CreateFile("./output/summary.dropped.txt", func(out io.Writer) error {
+			fmt.Fprintf(out, "packets\t%d\n", bufferTotal.Dropped)
+			fmt.Fprintf(out, "bytes\t%d\n", bufferTotal.DroppedBytes)
+			return nil
+		})
+	})
 	dumpers = append(dumpers, func() error {
 		err := bufferTotal.ByTime.Dump(w.StartTime(), "./output/summary.buffer_by_time.txt")
 		if err != nil {
@@ -171,48 +436,426 @@ func main() {
 		return bufferTotal.Histogram.Dump("./output/summary.buffer_histogram.txt")
 	})
 
-	demux := hw.NewIPv6DestinationDemux(
-		func(dstIP net.IP) hw.Handler {
-			bufferOutput := NewBufferStatistics(1 * time.Millisecond)
+	var fabric *hw.Crossbar
+	var fabricOutputs []string
+	if *crossbar {
+		fabric = hw.NewCrossbar(portBandwidth, *crossbarSpeedup, *crossbarIterations)
+		fabric.FIFO = *crossbarFIFO
+
+		voqs := make(map[[2]int]*stat.QueueStatistics)
+		fabric.OnQueueChange = func(w *hw.World, input, output int, bytes int) {
+			key := [2]int{input, output}
+			voq, ok := voqs[key]
+			if !ok {
+				voq = stat.NewQueueStatistics(1 * time.Millisecond)
+				name := fmt.Sprintf("%d.%s", input, fabricOutputs[output])
+				dumpers = append(dumpers, func() error {
+					return voq.Dump(
+						w,
+						fmt.Sprintf("./output/crossbar.voq_by_time.%s.txt", name),
+						fmt.Sprintf("./output/crossbar.voq_histogram.%s.txt", name),
+					)
+				})
+				voqs[key] = voq
+			}
+			voq.Set(w.Time(), bytes)
+		}
+
+		dumpers = append(dumpers, func() error {
+			return stat.CreateFile("./output/crossbar.hol_blocked.txt", func(out io.Writer) error {
+				for i := 0; i < fabric.Inputs(); i++ {
+					fmt.Fprintf(out, "%d\t%d\n", i, int64(fabric.HOLBlocked(i)))
+				}
+				return nil
+			})
+		})
+	}
+
+	ecmpFields, err := hw.ParseHashFields(*ecmpHash)
+	if err != nil {
+		log.Fatal(err)
+	}
+	hash, err := hw.NewFlowHash(ecmpFields, *ecmpHashFunc, uint32(*ecmpSeed))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// LAGs behind ECMP groups hash the same flows again. A salt keeps
+	// their member choice independent of the ECMP member choice, which a
+	// different seed alone does not do for CRC hashes.
+	lagFields, err := hw.ParseHashFields(*lagHash)
+	if err != nil {
+		log.Fatal(err)
+	}
+	lagFlowHash, err := hw.NewFlowHash(lagFields, *lagHashFunc, uint32(*lagSeed))
+	if err != nil {
+		log.Fatal(err)
+	}
+	lagFlowHash.Salt = lagHashSalt
+
+	lags, err := parseLAGs(*lagFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// newBalancer returns a LAG or ECMP group (kind is "lag" or "ecmp")
+	// that balances packets by the policy.
+	newBalancer := func(kind, policy, name string, hash *hw.FlowHash, drop hw.DropHandler) hw.Balancer {
+		count := func(what string, n *int64) {
 			dumpers = append(dumpers, func() error {
-				err := bufferOutput.ByTime.Dump(w.StartTime(), fmt.Sprintf("./output/output.buffer_by_time.%s.txt", dstIP.String()))
-				if err != nil {
+				return stat.CreateFile(fmt.Sprintf("./output/%s.%s.%s.txt", kind, what, name), func(out io.Writer) error {
+					_, err := fmt.Fprintf(out, "%d\n", *n)
 					return err
-				}
-				return bufferOutput.Histogram.Dump(fmt.Sprintf("./output/output.buffer_histogram.%s.txt", dstIP.String()))
+				})
 			})
+		}
+		switch policy {
+		case "hash":
+			group := hw.NewHashBalancer(kind, name, hash)
+			group.Drop = drop
+			count("rehashed", &group.Rehashed)
+			return group
+		case "flowlet", "least-queued":
+			group := hw.NewFlowletBalancer(name, hash, *flowletTimeout)
+			group.CongestionAware = policy == "least-queued"
+			group.Drop = drop
+			count("flowlets", &group.Flowlets)
+			return group
+		}
+		log.Fatalf("unknown %s balancing policy %q", kind, policy)
+		return nil
+	}
 
-			output := hw.Handler(hw.NullHandler{})
+	newPort := func(name string) *egressPort {
+		port := &egressPort{}
+		bufferOutput := stat.NewBufferStatistics(name, 1*time.Millisecond)
+		bufferOutput.Drop = dropSink
+		dumpers = append(dumpers, func() error {
+			err := bufferOutput.ByTime.Dump(w.StartTime(), fmt.Sprintf("./output/output.buffer_by_time.%s.txt", name))
+			if err != nil {
+				return err
+			}
+			return bufferOutput.Histogram.Dump(fmt.Sprintf("./output/output.buffer_histogram.%s.txt", name))
+		})
+
+		output := hw.Handler(hw.HandlerFunc(func(w *hw.World, p *hw.Packet) {
+			for _, departed := range port.departed {
+				departed(w, p)
+			}
+			hw.NullHandler{}.HandlePacket(w, p)
+		}))
+
+		output = bufferTotal.PortOutput(output)
+
+		output = bufferOutput.PortOutput(output)
+
+		if *egressPcap != "" {
+			pcapWriter, err := hw.NewPcapWriter(fmt.Sprintf("./output/output.packets.%s.%s", name, *egressPcap), linkType, output)
+			if err != nil {
+				log.Fatal(err)
+			}
+			dumpers = append(dumpers, pcapWriter.Close)
+			output = pcapWriter
+		}
+
+		if lag, ok := lags[name]; ok {
+			group := newBalancer("lag", *lagBalance, name, lagFlowHash, bufferTotal.PortDrop(bufferOutput.PortDrop(dropSink)))
+
+			load := stat.NewMemberLoad(lag.Members)
+			dumpers = append(dumpers, func() error {
+				return load.Dump(fmt.Sprintf("./output/lag.load.%s.txt", name))
+			})
+
+			reorderCounter := stat.NewReorderCounter(reorderIdleTimeout, output)
+			dumpers = append(dumpers, func() error {
+				return reorderCounter.Dump(fmt.Sprintf("./output/lag.reordered.%s.txt", name))
+			})
+			output = reorderCounter
+
+			for i := 0; i < lag.Members; i++ {
+				memberName := fmt.Sprintf("%s.%d", name, i)
+
+				bufferMember := stat.NewBufferStatistics(memberName, 1*time.Millisecond)
+				bufferMember.Drop = dropSink
+				dumpers = append(dumpers, func() error {
+					err := bufferMember.ByTime.Dump(w.StartTime(), fmt.Sprintf("./output/output.buffer_by_time.%s.txt", memberName))
+					if err != nil {
+						return err
+					}
+					return bufferMember.Histogram.Dump(fmt.Sprintf("./output/output.buffer_histogram.%s.txt", memberName))
+				})
+
+				transmitter := hw.NewTransmitter(lag.Bandwidth, bufferMember.PortOutput(output))
+				transmitter.Name = memberName
+				transmitter.Drop = bufferTotal.PortDrop(bufferOutput.PortDrop(bufferMember.PortDrop(dropSink)))
+				if portSchedule != nil {
+					portSchedule.Attach(w, transmitter)
+				}
+
+				group.AddMember(transmitter, load.Member(i, bufferMember.PortInput(transmitter)))
+			}
+			output = group
+			port.link = group
+		} else {
+			transmitter := hw.NewTransmitter(portBandwidth, output)
+			transmitter.Name = name
+			transmitter.Drop = bufferTotal.PortDrop(bufferOutput.PortDrop(dropSink))
+			if portSchedule != nil {
+				portSchedule.Attach(w, transmitter)
+			}
+			output = transmitter
+			port.link = transmitter
+		}
+
+		output = bufferOutput.PortInput(output)
+
+		sourceCounter := stat.NewIPSourceCounter(10*time.Millisecond, output)
+		sourceCounter.Decapsulate = *decap
+		dumpers = append(dumpers, func() error {
+			return sourceCounter.Dump(w, fmt.Sprintf("./output/output.sources.%s.txt", name))
+		})
+		output = sourceCounter
+
+		if fabric != nil {
+			fabricOutputs = append(fabricOutputs, name)
+			output = fabric.Output(output)
+		}
+
+		port.input = output
+		return port
+	}
+
+	// getPort returns the egress port with the given name, it is used
+	// where several elements may refer to the same port.
+	ports := make(map[string]*egressPort)
+	getEgressPort := func(name string) *egressPort {
+		port, ok := ports[name]
+		if !ok {
+			port = newPort(name)
+			ports[name] = port
+		}
+		return port
+	}
+	getPort := func(name string) hw.Handler {
+		return getEgressPort(name).input
+	}
+
+	drop := hw.Handler(hw.NullHandler{})
+	drop = bufferTotal.PortOutput(drop)
+
+	var forwarding hw.Handler
+	var l2Ports []hw.Handler
+	if *l2 {
+		// Every input is a port of the bridge; frames leave through the
+		// egress port with the same index.
+		bridge := hw.NewL2Switch(*l2Aging)
+		bridge.Drop = drop
 
-			output = bufferTotal.PortOutput(output)
+		tableSize := stat.NewGauge(10*time.Millisecond, func() int64 {
+			return int64(bridge.TableSize())
+		})
+		dumpers = append(dumpers, func() error {
+			return tableSize.Dump(w, "./output/l2.mac_table_size.txt")
+		})
+		flooded := stat.NewDelta(10*time.Millisecond, func() int64 {
+			return bridge.FloodedBytes
+		})
+		dumpers = append(dumpers, func() error {
+			return flooded.Dump(w, "./output/l2.flooded_bytes.txt")
+		})
+		dumpers = append(dumpers, func() error {
+			return stat.CreateFile("./output/l2.summary.txt", func(out io.Writer) error {
+				fmt.Fprintf(out, "flooded\t%d\n", bridge.Flooded)
+				fmt.Fprintf(out, "flooded_bytes\t%d\n", bridge.FloodedBytes)
+				fmt.Fprintf(out, "filtered\t%d\n", bridge.Filtered)
+				return nil
+			})
+		})
 
-			output = bufferOutput.PortOutput(output)
+		for i := range sources {
+			egress := getPort(fmt.Sprintf("port%d", i))
+			port := bridge.AddPort(egress, bufferTotal.PortInput(egress))
+			l2Ports = append(l2Ports, flooded.Sample(tableSize.Sample(port)))
+		}
+	} else if *lfibFlag != "" {
+		table, err := hw.LoadLFIB(*lfibFlag)
+		if err != nil {
+			log.Fatal(err)
+		}
+		labelSwitch := hw.NewLabelSwitch(table, getPort, drop)
+		labelSwitch.OnResize = bufferTotal.Resize
+		dumpers = append(dumpers, func() error {
+			return stat.CreateFile("./output/lfib.summary.txt", func(out io.Writer) error {
+				for _, e := range table.Entries {
+					fmt.Fprintf(out, "%d\t%s\t%d\t%s\t%d\t%d\n", e.Label, e.Action, e.OutLabel, e.Port, e.Packets, e.Bytes)
+				}
+				fmt.Fprintf(out, "unlabeled\t%d\n", labelSwitch.Unlabeled)
+				fmt.Fprintf(out, "unknown_label\t%d\n", labelSwitch.UnknownLabel)
+				return nil
+			})
+		})
+		forwarding = labelSwitch
+	} else if *routes != "" {
+		table, err := hw.LoadRoutes(*routes)
+		if err != nil {
+			log.Fatal(err)
+		}
+		// A route may point to a comma-separated list of ports, which
+		// forms an ECMP group. Ports can be shared between groups.
+		newOutput := func(name string) hw.Handler {
+			names := strings.Split(name, ",")
+			if len(names) == 1 {
+				return getPort(name)
+			}
 
-			output = hw.NewTransmitter(10*1000*1000*1000, output)
+			group := newBalancer("ecmp", *ecmpBalance, name, hash, bufferTotal.PortDrop(dropSink))
 
-			output = bufferOutput.PortInput(output)
+			load := stat.NewMemberLoad(len(names))
+			dumpers = append(dumpers, func() error {
+				return load.Dump(fmt.Sprintf("./output/ecmp.load.%s.txt", name))
+			})
 
-			sourceCounter := stat.NewIPv6SourceCounter(10*time.Millisecond, output)
+			// The members of the group are separate ports, so reordering
+			// is counted where packets leave any of them.
+			reorderCounter := stat.NewReorderCounter(reorderIdleTimeout, nil)
 			dumpers = append(dumpers, func() error {
-				return sourceCounter.Dump(w, fmt.Sprintf("./output/output.sources.%s.txt", dstIP.String()))
+				return reorderCounter.Dump(fmt.Sprintf("./output/ecmp.reordered.%s.txt", name))
 			})
-			output = sourceCounter
 
-			return output
-		},
-		func() hw.Handler {
-			output := hw.Handler(hw.NullHandler{})
+			for i, member := range names {
+				port := getEgressPort(member)
+				port.departed = append(port.departed, reorderCounter.Count)
+				group.AddMember(port.link, load.Member(i, port.input))
+			}
+			return group
+		}
+
+		router := hw.NewRouter(table, newOutput, drop)
+		dumpers = append(dumpers, func() error {
+			return stat.CreateFile("./output/summary.unrouted.txt", func(out io.Writer) error {
+				_, err := fmt.Fprintf(out, "%d\n", router.Unrouted)
+				return err
+			})
+		})
+		forwarding = router
+	} else {
+		key, err := hw.ParseKeyFunc(*demuxKey)
+		if err != nil {
+			log.Fatal(err)
+		}
+		demux := hw.NewDemux(key, getPort, drop)
+		demux.MaxKeys = *demuxMaxKeys
+		demux.Decapsulate = *decap
+		dumpers = append(dumpers, func() error {
+			return stat.CreateFile("./output/summary.demux_overflowed.txt", func(out io.Writer) error {
+				_, err := fmt.Fprintf(out, "%d\n", demux.Overflowed)
+				return err
+			})
+		})
+		forwarding = demux
+	}
 
-			output = bufferTotal.PortOutput(output)
+	var replication *hw.ReplicationTable
+	var replicaBytes *stat.Delta
+	if *replicate != "" {
+		var err error
+		replication, err = hw.LoadReplicationTable(*replicate)
+		if err != nil {
+			log.Fatal(err)
+		}
+		replicaBytes = stat.NewDelta(10*time.Millisecond, func() int64 {
+			var total int64
+			for _, g := range replication.Groups {
+				total += g.ReplicaBytes
+			}
+			return total
+		})
+		dumpers = append(dumpers, func() error {
+			return replicaBytes.Dump(w, "./output/replication.replica_bytes.txt")
+		})
+		dumpers = append(dumpers, func() error {
+			return stat.CreateFile("./output/replication.summary.txt", func(out io.Writer) error {
+				for _, g := range replication.Groups {
+					factor := 0.0
+					if g.Packets != 0 {
+						factor = float64(g.Copies) / float64(g.Packets)
+					}
+					fmt.Fprintf(out, "%s\t%d\t%d\t%.2f\t%d\n", g.Name, g.Packets, g.Copies, factor, g.ReplicaBytes)
+				}
+				return nil
+			})
+		})
+	}
 
-			return output
-		}(),
-	)
+	var aclRules []*hw.ACLRule
+	var aclGauges []*stat.Delta
+	if *aclFlag != "" {
+		var err error
+		aclRules, err = hw.LoadACL(*aclFlag)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for i, rule := range aclRules {
+			i, rule := i, rule
+			hits := stat.NewDelta(10*time.Millisecond, func() int64 {
+				return rule.Hits
+			})
+			dumpers = append(dumpers, func() error {
+				return hits.Dump(w, fmt.Sprintf("./output/acl.hits_by_time.%d.txt", i))
+			})
+			aclGauges = append(aclGauges, hits)
+		}
+		dumpers = append(dumpers, func() error {
+			return stat.CreateFile("./output/acl.summary.txt", func(out io.Writer) error {
+				for i, rule := range aclRules {
+					fmt.Fprintf(out, "%d\t%d\t%d\t%s\n", i, rule.Hits, rule.HitBytes, rule.Text)
+				}
+				return nil
+			})
+		})
+	}
 
 	for i, source := range sources {
 		i := i // freeze i value for dumpers
 
-		output := hw.Handler(demux)
+		output := forwarding
+		if l2Ports != nil {
+			output = l2Ports[i]
+		}
+
+		if replication != nil {
+			newReplica := func(name string) hw.Handler {
+				return bufferTotal.PortInput(getPort(name))
+			}
+			replicator := hw.NewReplicator(replication, output, getPort, newReplica, drop)
+			dumpers = append(dumpers, func() error {
+				return stat.CreateFile(fmt.Sprintf("./output/input.replication_unknown.%d.txt", i), func(out io.Writer) error {
+					_, err := fmt.Fprintf(out, "%d\n", replicator.Unknown)
+					return err
+				})
+			})
+			output = replicaBytes.Sample(replicator)
+		}
+
+		if aclRules != nil {
+			acl := hw.NewACL(aclRules, output, getPort, drop)
+			acl.Decapsulate = *decap
+			dumpers = append(dumpers, func() error {
+				return stat.CreateFile(fmt.Sprintf("./output/input.acl_unmatched.%d.txt", i), func(out io.Writer) error {
+					fmt.Fprintf(out, "packets\t%d\n", acl.Unmatched)
+					fmt.Fprintf(out, "bytes\t%d\n", acl.UnmatchedBytes)
+					return nil
+				})
+			})
+			output = acl
+			for _, hits := range aclGauges {
+				output = hits.Sample(output)
+			}
+		}
+
+		if fabric != nil {
+			output = fabric.Input(output)
+		}
 
 		interarrivalTime := stat.NewInterarrivalTime(output)
 		dumpers = append(dumpers, func() error {
@@ -232,13 +875,70 @@ func main() {
 		})
 		output = packetsPerSecond
 
+		if *extHeaders {
+			extHeaderCounter := stat.NewExtHeaderCounter(output)
+			dumpers = append(dumpers, func() error {
+				return extHeaderCounter.Dump(fmt.Sprintf("./output/input.ext_headers.%d.txt", i))
+			})
+			output = extHeaderCounter
+		}
+
 		output = bufferTotal.PortInput(output)
 
-		hw.NewReceiver(w, source, source.LinkType(), 40*1000*1000*1000, output)
+		if *classify != "" {
+			f, err := filter.Compile(*classify)
+			if err != nil {
+				log.Fatal(err)
+			}
+			f.Decapsulate = *decap
+			noMatch := hw.Handler(hw.HandlerFunc(func(w *hw.World, p *hw.Packet) {
+				dropSink.HandleDrop(w, p, hw.Drop{Reason: "classifier"})
+			}))
+			if *classifyPort != "" {
+				noMatch = bufferTotal.PortInput(getPort(*classifyPort))
+			}
+			classifier := filter.NewClassifier(f, output, noMatch)
+			dumpers = append(dumpers, func() error {
+				return stat.CreateFile(fmt.Sprintf("./output/input.classifier.%d.txt", i), func(out io.Writer) error {
+					fmt.Fprintf(out, "matched\t%d\n", classifier.Matched)
+					fmt.Fprintf(out, "matched_bytes\t%d\n", classifier.MatchedBytes)
+					fmt.Fprintf(out, "not_matched\t%d\n", classifier.NotMatched)
+					fmt.Fprintf(out, "not_matched_bytes\t%d\n", classifier.NotMatchedBytes)
+					return nil
+				})
+			})
+			output = classifier
+		}
+
+		if *impair != "" {
+			impairment := hw.NewImpairment(output)
+			if err := impairment.Configure(*impair); err != nil {
+				log.Fatal(err)
+			}
+			impairment.Drop = dropSink
+			dumpers = append(dumpers, func() error {
+				return stat.CreateFile(fmt.Sprintf("./output/input.impairment.%d.txt", i), func(out io.Writer) error {
+					fmt.Fprintf(out, "lost\t%d\n", impairment.Lost)
+					fmt.Fprintf(out, "corrupted\t%d\n", impairment.Corrupted)
+					fmt.Fprintf(out, "duplicated\t%d\n", impairment.Duplicated)
+					fmt.Fprintf(out, "reordered\t%d\n", impairment.Reordered)
+					return nil
+				})
+			})
+			output = impairment
+		}
+
+		output = hw.NewInPortSetter(i, output)
+
+		hw.NewReceiver(w, newPacketSource(i, source), source.LinkType(), 40*1000*1000*1000, output)
 	}
 
 	w.Simulate()
+	dump(dumpers)
+}
 
+// dump writes the statistics once the simulation is over.
+func dump(dumpers []func() error) {
 	for _, d := range dumpers {
 		err := d()
 		if err != nil {
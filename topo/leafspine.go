@@ -0,0 +1,365 @@
+package topo
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dmage/switchemu/hw"
+	"github.com/dmage/switchemu/stat"
+)
+
+// HostMapping assigns the hosts of a prefix to a leaf switch.
+type HostMapping struct {
+	Prefix *net.IPNet
+	Leaf   string
+
+	// Port is the host port of the leaf. If it is empty, the prefixes of
+	// a leaf are spread over its host ports in order.
+	Port string
+}
+
+// LoadHostMapping reads a host mapping from a file. See ParseHostMapping for
+// the format.
+func LoadHostMapping(filename string) ([]HostMapping, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	hosts, err := ParseHostMapping(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+	return hosts, nil
+}
+
+// ParseHostMapping reads a host mapping, one prefix per line:
+//
+//	# prefix        leaf    [port]
+//	10.1.0.0/24     leaf1
+//	10.1.1.0/24     leaf1   host2
+//	2001:db8:1::/48 leaf2
+func ParseHostMapping(r io.Reader) ([]HostMapping, error) {
+	var hosts []HostMapping
+	scanner := bufio.NewScanner(r)
+	lineno := 0
+	for scanner.Scan() {
+		lineno++
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i != -1 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("line %d: expected prefix, leaf and optional port", lineno)
+		}
+		_, prefix, err := net.ParseCIDR(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", lineno, err)
+		}
+		h := HostMapping{
+			Prefix: prefix,
+			Leaf:   fields[1],
+		}
+		if len(fields) == 3 {
+			h.Port = fields[2]
+		}
+		hosts = append(hosts, h)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return hosts, nil
+}
+
+// LeafSpine describes a two-tier Clos fabric. Every leaf is connected to
+// every spine by one uplink. The number of host ports of a leaf follows from
+// the oversubscription ratio, i.e. the ratio of the host bandwidth to the
+// uplink bandwidth of a leaf.
+type LeafSpine struct {
+	Leaves           int
+	Spines           int
+	HostBandwidth    int64
+	UplinkBandwidth  int64
+	Oversubscription float64
+	LinkDelay        time.Duration
+	BufferLimit      int
+
+	// Hosts assigns prefixes to leaves. Packets enter the fabric at the
+	// leaf of their source address and leave it at the leaf of their
+	// destination address.
+	Hosts []HostMapping
+
+	// Border is the leaf that connects the fabric to the outside. Packets
+	// to addresses that are not in Hosts leave the fabric through its port
+	// "external", the spines have a default route toward it. If Border is
+	// empty, the spines drop such packets as unrouted.
+	Border string
+
+	// HashSeed is the seed of the ECMP hashes. Every switch also salts its
+	// hash with its own number, so that the leaves and the spines pick
+	// their uplinks independently.
+	HashSeed uint32
+}
+
+// HostPorts returns the number of host ports of a leaf.
+func (ls *LeafSpine) HostPorts() int {
+	n := int(math.Floor(ls.Oversubscription*float64(ls.Spines)*float64(ls.UplinkBandwidth)/float64(ls.HostBandwidth) + 0.5))
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+func leafName(i int) string {
+	return fmt.Sprintf("leaf%d", i+1)
+}
+
+func spineName(i int) string {
+	return fmt.Sprintf("spine%d", i+1)
+}
+
+// Build adds the switches and links of the fabric to the network. The
+// switches are named leaf1..leafN and spine1..spineM, leaf i is connected
+// to spine j through the ports leafi:upj and spinej:downi. Inputs that are
+// not attached otherwise enter the fabric at the leaf of their source
+// address. Every switch gets its own ECMP hash, see HashSeed.
+func (ls *LeafSpine) Build(n *Network) error {
+	if ls.Leaves < 1 || ls.Spines < 1 {
+		return fmt.Errorf("leaf-spine fabric needs at least one leaf and one spine")
+	}
+	if ls.HostBandwidth <= 0 || ls.UplinkBandwidth <= 0 || ls.Oversubscription <= 0 {
+		return fmt.Errorf("leaf-spine fabric needs positive bandwidths and oversubscription")
+	}
+
+	hostPorts := ls.HostPorts()
+	leafRoutes := make([]*hw.RouteTable, ls.Leaves)
+	for i := range leafRoutes {
+		leafRoutes[i] = hw.NewRouteTable()
+	}
+	spineRoutes := hw.NewRouteTable()
+	hostRoutes := hw.NewRouteTable()
+
+	leafIndex := make(map[string]int)
+	for i := 0; i < ls.Leaves; i++ {
+		leafIndex[leafName(i)] = i
+	}
+	border := -1
+	if ls.Border != "" {
+		i, ok := leafIndex[ls.Border]
+		if !ok {
+			return fmt.Errorf("unknown border leaf %q", ls.Border)
+		}
+		border = i
+	}
+
+	var uplinks []string
+	for j := 0; j < ls.Spines; j++ {
+		uplinks = append(uplinks, fmt.Sprintf("up%d", j+1))
+	}
+	uplinkGroup := strings.Join(uplinks, ",")
+
+	assigned := make([]int, ls.Leaves)
+	for _, h := range ls.Hosts {
+		i, ok := leafIndex[h.Leaf]
+		if !ok {
+			return fmt.Errorf("%s: unknown leaf %q", h.Prefix, h.Leaf)
+		}
+		port := h.Port
+		if port == "" {
+			port = fmt.Sprintf("host%d", assigned[i]%hostPorts+1)
+			assigned[i]++
+		}
+		leafRoutes[i].Add(&hw.Route{Prefix: h.Prefix, Port: port})
+		spineRoutes.Add(&hw.Route{Prefix: h.Prefix, Port: fmt.Sprintf("down%d", i+1)})
+		hostRoutes.Add(&hw.Route{Prefix: h.Prefix, Port: h.Leaf + ":" + port})
+		if border != -1 && i != border {
+			// The default route of the border leaf leads out of the fabric.
+			leafRoutes[border].Add(&hw.Route{Prefix: h.Prefix, Port: uplinkGroup})
+		}
+	}
+
+	for _, prefix := range []string{"0.0.0.0/0", "::/0"} {
+		_, ipnet, _ := net.ParseCIDR(prefix)
+		for i, table := range leafRoutes {
+			if i == border {
+				table.Add(&hw.Route{Prefix: ipnet, Port: "external"})
+			} else {
+				table.Add(&hw.Route{Prefix: ipnet, Port: uplinkGroup})
+			}
+		}
+		if border != -1 {
+			spineRoutes.Add(&hw.Route{Prefix: ipnet, Port: fmt.Sprintf("down%d", border+1)})
+		}
+	}
+
+	// newHash returns the ECMP hash of the switch with the given number.
+	newHash := func(k int) (*hw.FlowHash, error) {
+		hash, err := hw.NewFlowHash(hw.HashFiveTuple, "crc32", ls.HashSeed)
+		if err != nil {
+			return nil, err
+		}
+		hash.Salt = uint32(k + 1)
+		return hash, nil
+	}
+
+	var leaves, spines []*Switch
+	for i := 0; i < ls.Leaves; i++ {
+		hash, err := newHash(i)
+		if err != nil {
+			return err
+		}
+		s, err := n.AddSwitch(leafName(i), SwitchConfig{
+			Routes:        leafRoutes[i],
+			PortBandwidth: ls.HostBandwidth,
+			BufferLimit:   ls.BufferLimit,
+			Hash:          hash,
+		})
+		if err != nil {
+			return err
+		}
+		leaves = append(leaves, s)
+	}
+	for j := 0; j < ls.Spines; j++ {
+		hash, err := newHash(ls.Leaves + j)
+		if err != nil {
+			return err
+		}
+		s, err := n.AddSwitch(spineName(j), SwitchConfig{
+			Routes:        spineRoutes,
+			PortBandwidth: ls.UplinkBandwidth,
+			BufferLimit:   ls.BufferLimit,
+			Hash:          hash,
+		})
+		if err != nil {
+			return err
+		}
+		spines = append(spines, s)
+	}
+	for i, leaf := range leaves {
+		for j, spine := range spines {
+			err := n.Connect(leaf, fmt.Sprintf("up%d", j+1), spine, fmt.Sprintf("down%d", i+1), ls.UplinkBandwidth, ls.LinkDelay)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	hosts := newSourceDispatcher(n, hostRoutes)
+	n.DefaultInput = hosts
+
+	n.dump(func() error {
+		return stat.CreateFile("./output/fabric.summary.txt", func(out io.Writer) error {
+			fmt.Fprintf(out, "leaves\t%d\n", ls.Leaves)
+			fmt.Fprintf(out, "spines\t%d\n", ls.Spines)
+			fmt.Fprintf(out, "host_ports\t%d\n", hostPorts)
+			fmt.Fprintf(out, "host_bandwidth\t%d\n", ls.HostBandwidth)
+			fmt.Fprintf(out, "uplink_bandwidth\t%d\n", ls.UplinkBandwidth)
+			fmt.Fprintf(out, "oversubscription\t%.2f\n", float64(hostPorts)*float64(ls.HostBandwidth)/(float64(ls.Spines)*float64(ls.UplinkBandwidth)))
+			fmt.Fprintf(out, "unknown_sources\t%d\n", hosts.Unknown)
+			return nil
+		})
+	})
+
+	return nil
+}
+
+// sourceDispatcher sends packets to the switch port of their source
+// address. The ports are stored in a route table as "switch:port".
+type sourceDispatcher struct {
+	network *Network
+	table   *hw.RouteTable
+	inputs  map[string]hw.Handler
+	headers hw.Headers
+
+	Unknown int64
+}
+
+func newSourceDispatcher(n *Network, table *hw.RouteTable) *sourceDispatcher {
+	return &sourceDispatcher{
+		network: n,
+		table:   table,
+		inputs:  make(map[string]hw.Handler),
+	}
+}
+
+func (d *sourceDispatcher) HandlePacket(w *hw.World, p *hw.Packet) {
+	if d.headers.Parse(p.CapturedData) {
+		if route := d.table.Lookup(d.headers.SrcIP); route != nil {
+			h, ok := d.inputs[route.Port]
+			if !ok {
+				parts := strings.SplitN(route.Port, ":", 2)
+				h = d.network.Switch(parts[0]).Input(parts[1])
+				d.inputs[route.Port] = h
+			}
+			h.HandlePacket(w, p)
+			return
+		}
+	}
+	d.Unknown++
+	hw.NullHandler{}.HandlePacket(w, p)
+}
+
+// parseLeafSpine parses the options of a leaf-spine statement.
+func parseLeafSpine(args []string, dir string) (*LeafSpine, error) {
+	ls := &LeafSpine{
+		Leaves:           2,
+		Spines:           2,
+		HostBandwidth:    25 * 1000 * 1000 * 1000,
+		UplinkBandwidth:  100 * 1000 * 1000 * 1000,
+		Oversubscription: 1,
+		Border:           leafName(0),
+	}
+	for _, arg := range args {
+		kv := strings.SplitN(arg, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid leaf-spine option %q", arg)
+		}
+		var err error
+		switch kv[0] {
+		case "leaves":
+			ls.Leaves, err = strconv.Atoi(kv[1])
+		case "spines":
+			ls.Spines, err = strconv.Atoi(kv[1])
+		case "host-bandwidth":
+			ls.HostBandwidth, err = hw.ParseBandwidth(kv[1])
+		case "uplink-bandwidth":
+			ls.UplinkBandwidth, err = hw.ParseBandwidth(kv[1])
+		case "oversubscription":
+			// Accept both "3" and "3:1".
+			ls.Oversubscription, err = strconv.ParseFloat(strings.TrimSuffix(kv[1], ":1"), 64)
+		case "delay":
+			ls.LinkDelay, err = time.ParseDuration(kv[1])
+		case "buffer-limit":
+			ls.BufferLimit, err = strconv.Atoi(kv[1])
+		case "hosts":
+			ls.Hosts, err = LoadHostMapping(resolvePath(dir, kv[1]))
+		case "border":
+			ls.Border = kv[1]
+			if ls.Border == "none" {
+				ls.Border = ""
+			}
+		case "hash-seed":
+			var v uint64
+			v, err = strconv.ParseUint(kv[1], 10, 32)
+			ls.HashSeed = uint32(v)
+		default:
+			return nil, fmt.Errorf("unknown leaf-spine option %q", kv[0])
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ls, nil
+}
@@ -0,0 +1,139 @@
+package topo
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/dmage/switchemu/hw"
+)
+
+func TestLeafSpine(t *testing.T) {
+	hosts, err := ParseHostMapping(strings.NewReader(`
+10.0.0.0/24      leaf1
+10.0.1.0/24      leaf1
+2001:db8:2::/48  leaf2  host9
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ls := &LeafSpine{
+		Leaves:           2,
+		Spines:           3,
+		HostBandwidth:    25 * 1000 * 1000 * 1000,
+		UplinkBandwidth:  100 * 1000 * 1000 * 1000,
+		Oversubscription: 2,
+		Hosts:            hosts,
+	}
+	if got := ls.HostPorts(); got != 24 {
+		t.Errorf("got %d host ports, want 24", got)
+	}
+
+	n := NewNetwork(&hw.World{}, hw.NullDropHandler{})
+	if err := ls.Build(n); err != nil {
+		t.Fatal(err)
+	}
+	if len(n.Switches) != 5 {
+		t.Fatalf("got %d switches, want 5", len(n.Switches))
+	}
+	for _, name := range []string{"leaf1", "leaf2"} {
+		if got := len(n.Switch(name).links); got != 3 {
+			t.Errorf("%s: got %d links, want 3", name, got)
+		}
+	}
+	if l := n.Switch("spine3").links["down2"]; l == nil || l.peer != n.Switch("leaf2") || l.peerPort != "up3" {
+		t.Errorf("spine3:down2: got link %+v, want a link to leaf2:up3", l)
+	}
+
+	router := n.Switch("leaf1").Forwarding.(*hw.Router)
+	testCases := []struct {
+		ip   string
+		port string
+	}{
+		{"10.0.0.1", "host1"},
+		{"10.0.1.1", "host2"},
+		{"10.9.0.1", "up1,up2,up3"},
+		{"2001:db8:2::1", "up1,up2,up3"},
+	}
+	for _, tc := range testCases {
+		ip := net.ParseIP(tc.ip)
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+		}
+		if route := router.Table.Lookup(ip); route == nil || route.Port != tc.port {
+			t.Errorf("leaf1: %s: got route %+v, want port %s", tc.ip, route, tc.port)
+		}
+	}
+
+	if n.Input(0) == nil {
+		t.Errorf("inputs are not attached to the fabric")
+	}
+
+	ls.Hosts = append(ls.Hosts, HostMapping{Prefix: hosts[0].Prefix, Leaf: "leaf7"})
+	if err := ls.Build(NewNetwork(&hw.World{}, hw.NullDropHandler{})); err == nil {
+		t.Errorf("expected an error for an unknown leaf")
+	}
+}
+
+func TestLeafSpineBorder(t *testing.T) {
+	_, prefix, _ := net.ParseCIDR("10.0.0.0/24")
+	ls := &LeafSpine{
+		Leaves:           2,
+		Spines:           2,
+		HostBandwidth:    25 * 1000 * 1000 * 1000,
+		UplinkBandwidth:  100 * 1000 * 1000 * 1000,
+		Oversubscription: 1,
+		Hosts:            []HostMapping{{Prefix: prefix, Leaf: "leaf1"}},
+	}
+	lookup := func(n *Network, name string, ip string) string {
+		route := n.Switch(name).Forwarding.(*hw.Router).Table.Lookup(net.ParseIP(ip).To4())
+		if route == nil {
+			return ""
+		}
+		return route.Port
+	}
+
+	n := NewNetwork(&hw.World{}, hw.NullDropHandler{})
+	if err := ls.Build(n); err != nil {
+		t.Fatal(err)
+	}
+	if port := lookup(n, "spine1", "192.0.2.1"); port != "" {
+		t.Errorf("spine1: got route to port %s without a border leaf, want none", port)
+	}
+
+	ls.Border = "leaf2"
+	n = NewNetwork(&hw.World{}, hw.NullDropHandler{})
+	if err := ls.Build(n); err != nil {
+		t.Fatal(err)
+	}
+	testCases := []struct {
+		name string
+		ip   string
+		port string
+	}{
+		{"leaf1", "192.0.2.1", "up1,up2"},
+		{"leaf2", "192.0.2.1", "external"},
+		{"leaf2", "10.0.0.1", "up1,up2"},
+		{"spine1", "192.0.2.1", "down2"},
+		{"spine2", "10.0.0.1", "down1"},
+	}
+	for _, tc := range testCases {
+		if port := lookup(n, tc.name, tc.ip); port != tc.port {
+			t.Errorf("%s: %s: got port %q, want %s", tc.name, tc.ip, port, tc.port)
+		}
+	}
+
+	salts := make(map[uint32]string)
+	for _, s := range n.Switches {
+		if other, ok := salts[s.hash.Salt]; ok {
+			t.Errorf("%s and %s have the same hash salt %d", s.Name, other, s.hash.Salt)
+		}
+		salts[s.hash.Salt] = s.Name
+	}
+
+	ls.Border = "leaf3"
+	if err := ls.Build(NewNetwork(&hw.World{}, hw.NullDropHandler{})); err == nil {
+		t.Errorf("expected an error for an unknown border leaf")
+	}
+}
@@ -0,0 +1,347 @@
+// Package topo builds networks of several switches that share one
+// hw.World.
+package topo
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dmage/switchemu/hw"
+)
+
+// SwitchConfig describes a switch that is added to a Network.
+type SwitchConfig struct {
+	// Routes is the route table of the switch. If it is nil, the switch
+	// creates an egress port for every key of DemuxKey.
+	Routes   *hw.RouteTable
+	DemuxKey hw.KeyFunc
+
+	// LFIB makes the switch a label switching router that forwards MPLS
+	// packets by their top label. It takes precedence over Routes.
+	LFIB *hw.LFIB
+
+	// PortBandwidth is the bandwidth of egress ports that are not
+	// connected to another switch. Zero means 10 Gbit/s.
+	PortBandwidth int64
+
+	// BufferLimit is the size of the shared buffer of the switch in bytes.
+	// Zero means unlimited.
+	BufferLimit int
+
+	// Hash selects the members of ECMP groups. Switches with the same hash
+	// function and seed make the same choices, which leads to polarization.
+	Hash *hw.FlowHash
+}
+
+// Network is a set of switches connected by links. Inputs are numbered
+// ports of the switches through which captured traffic enters the network.
+type Network struct {
+	Switches []*Switch
+	Schedule *hw.Schedule
+
+	// DefaultInput receives the packets of inputs that are not attached to
+	// a switch port. It may be nil.
+	DefaultInput hw.Handler
+
+	// Dumpers write the statistics of the network once the simulation is
+	// over.
+	Dumpers []func() error
+
+	world    *hw.World
+	drop     hw.DropHandler
+	switches map[string]*Switch
+	inputs   map[int]hw.Handler
+}
+
+// NewNetwork returns an empty network. Packets that are dropped by the
+// switches are passed to drop.
+func NewNetwork(w *hw.World, drop hw.DropHandler) *Network {
+	return &Network{
+		world:    w,
+		drop:     drop,
+		switches: make(map[string]*Switch),
+		inputs:   make(map[int]hw.Handler),
+	}
+}
+
+func (n *Network) dump(fn func() error) {
+	n.Dumpers = append(n.Dumpers, fn)
+}
+
+// AddSwitch adds a switch to the network.
+func (n *Network) AddSwitch(name string, config SwitchConfig) (*Switch, error) {
+	if _, ok := n.switches[name]; ok {
+		return nil, fmt.Errorf("duplicate switch %q", name)
+	}
+	if strings.ContainsAny(name, ":/") {
+		return nil, fmt.Errorf("invalid switch name %q", name)
+	}
+
+	hash := config.Hash
+	if hash == nil {
+		var err error
+		hash, err = hw.NewFlowHash(hw.HashFiveTuple, "crc32", 0)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	s := newSwitch(n, name, config.BufferLimit, hash)
+	if config.PortBandwidth != 0 {
+		s.PortBandwidth = config.PortBandwidth
+	}
+	if config.LFIB != nil {
+		s.SetLabels(config.LFIB)
+	} else if config.Routes != nil {
+		s.SetRoutes(config.Routes)
+	} else {
+		key := config.DemuxKey
+		if key.Extract == nil {
+			var err error
+			key, err = hw.ParseKeyFunc("dst-ip")
+			if err != nil {
+				return nil, err
+			}
+		}
+		s.SetDemux(key)
+	}
+
+	n.Switches = append(n.Switches, s)
+	n.switches[name] = s
+	return s, nil
+}
+
+// Switch returns the switch with the given name, or nil.
+func (n *Network) Switch(name string) *Switch {
+	return n.switches[name]
+}
+
+// Connect connects the port aPort of the switch a with the port bPort of
+// the switch b by a full-duplex link.
+func (n *Network) Connect(a *Switch, aPort string, b *Switch, bPort string, bandwidth int64, delay time.Duration) error {
+	for _, end := range []struct {
+		s    *Switch
+		port string
+	}{{a, aPort}, {b, bPort}} {
+		if _, ok := end.s.links[end.port]; ok {
+			return fmt.Errorf("port %s:%s is already connected", end.s.Name, end.port)
+		}
+		if _, ok := end.s.ports[end.port]; ok {
+			return fmt.Errorf("port %s:%s is already in use", end.s.Name, end.port)
+		}
+	}
+	a.links[aPort] = &link{bandwidth: bandwidth, delay: delay, peer: b, peerPort: bPort}
+	b.links[bPort] = &link{bandwidth: bandwidth, delay: delay, peer: a, peerPort: aPort}
+	return nil
+}
+
+// AttachInput makes packets of the input with the given index enter the
+// network through port of the switch s.
+func (n *Network) AttachInput(input int, s *Switch, port string) error {
+	if _, ok := n.inputs[input]; ok {
+		return fmt.Errorf("input %d is already attached", input)
+	}
+	n.inputs[input] = s.Input(port)
+	return nil
+}
+
+// Input returns the handler for packets of the input with the given index,
+// or DefaultInput if the input is not attached to a switch port.
+func (n *Network) Input(input int) hw.Handler {
+	if h, ok := n.inputs[input]; ok {
+		return h
+	}
+	return n.DefaultInput
+}
+
+// Load reads a topology from a file. See Parse for the format. Relative
+// file names in the topology are relative to the directory of the file.
+func (n *Network) Load(filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := n.Parse(f, filepath.Dir(filename)); err != nil {
+		return fmt.Errorf("%s: %s", filename, err)
+	}
+	return nil
+}
+
+func resolvePath(dir, filename string) string {
+	if filepath.IsAbs(filename) {
+		return filename
+	}
+	return filepath.Join(dir, filename)
+}
+
+func parseEndpoint(n *Network, s string) (*Switch, string, error) {
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return nil, "", fmt.Errorf("invalid port %q: expected switch:port", s)
+	}
+	sw := n.Switch(parts[0])
+	if sw == nil {
+		return nil, "", fmt.Errorf("unknown switch %q", parts[0])
+	}
+	return sw, parts[1], nil
+}
+
+// Parse reads a topology, one statement per line:
+//
+//	# switch NAME [routes=FILE] [lfib=FILE] [demux-key=KEY]
+//	#             [bandwidth=BW] [buffer-limit=BYTES] [hash-seed=N]
+//	switch leaf1  routes=leaf1.routes buffer-limit=4000000
+//	switch spine1 routes=spine1.routes
+//
+//	# link SWITCH:PORT SWITCH:PORT BANDWIDTH [DELAY]
+//	link leaf1:up1 spine1:down1 100G 500ns
+//
+//	# input INDEX SWITCH:PORT
+//	input 0 leaf1:host0
+//
+//	# leaf-spine [leaves=N] [spines=N] [host-bandwidth=BW]
+//	#            [uplink-bandwidth=BW] [oversubscription=R[:1]]
+//	#            [delay=D] [buffer-limit=BYTES] [hosts=FILE]
+//	#            [border=LEAF|none] [hash-seed=N]
+//	leaf-spine leaves=4 spines=2 uplink-bandwidth=100G oversubscription=3:1 hosts=hosts.txt
+//
+// Switches must be declared before they are used. A switch with a label
+// forwarding table (see hw.ParseLFIB) forwards MPLS packets by their top
+// label. A switch without a route table creates an egress port for every
+// key of its demux key, dst-ip by default. Egress ports that are not
+// connected by a link deliver packets out of the network. The leaf-spine
+// statement adds a whole fabric (see LeafSpine), its hosts option names a
+// host mapping file (see ParseHostMapping) and its border option the leaf
+// that leads out of the fabric, leaf1 by default. Relative file names are
+// resolved against dir.
+func (n *Network) Parse(r io.Reader, dir string) error {
+	scanner := bufio.NewScanner(r)
+	lineno := 0
+	for scanner.Scan() {
+		lineno++
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i != -1 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		var err error
+		switch fields[0] {
+		case "switch":
+			err = n.parseSwitch(fields[1:], dir)
+		case "link":
+			err = n.parseLink(fields[1:])
+		case "input":
+			err = n.parseInput(fields[1:])
+		case "leaf-spine":
+			var ls *LeafSpine
+			ls, err = parseLeafSpine(fields[1:], dir)
+			if err == nil {
+				err = ls.Build(n)
+			}
+		default:
+			err = fmt.Errorf("unknown statement %q", fields[0])
+		}
+		if err != nil {
+			return fmt.Errorf("line %d: %s", lineno, err)
+		}
+	}
+	return scanner.Err()
+}
+
+func (n *Network) parseSwitch(args []string, dir string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("switch: missing name")
+	}
+	var config SwitchConfig
+	seed := uint32(0)
+	for _, arg := range args[1:] {
+		kv := strings.SplitN(arg, "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("invalid switch option %q", arg)
+		}
+		var err error
+		switch kv[0] {
+		case "routes":
+			config.Routes, err = hw.LoadRoutes(resolvePath(dir, kv[1]))
+		case "lfib":
+			config.LFIB, err = hw.LoadLFIB(resolvePath(dir, kv[1]))
+		case "demux-key":
+			config.DemuxKey, err = hw.ParseKeyFunc(kv[1])
+		case "bandwidth":
+			config.PortBandwidth, err = hw.ParseBandwidth(kv[1])
+		case "buffer-limit":
+			config.BufferLimit, err = strconv.Atoi(kv[1])
+		case "hash-seed":
+			var v uint64
+			v, err = strconv.ParseUint(kv[1], 10, 32)
+			seed = uint32(v)
+		default:
+			return fmt.Errorf("unknown switch option %q", kv[0])
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if seed != 0 {
+		var err error
+		config.Hash, err = hw.NewFlowHash(hw.HashFiveTuple, "crc32", seed)
+		if err != nil {
+			return err
+		}
+	}
+	_, err := n.AddSwitch(args[0], config)
+	return err
+}
+
+func (n *Network) parseLink(args []string) error {
+	if len(args) < 3 || len(args) > 4 {
+		return fmt.Errorf("link: expected two ports, bandwidth and optional delay")
+	}
+	a, aPort, err := parseEndpoint(n, args[0])
+	if err != nil {
+		return err
+	}
+	b, bPort, err := parseEndpoint(n, args[1])
+	if err != nil {
+		return err
+	}
+	bandwidth, err := hw.ParseBandwidth(args[2])
+	if err != nil {
+		return err
+	}
+	var delay time.Duration
+	if len(args) == 4 {
+		delay, err = time.ParseDuration(args[3])
+		if err != nil {
+			return err
+		}
+	}
+	return n.Connect(a, aPort, b, bPort, bandwidth, delay)
+}
+
+func (n *Network) parseInput(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("input: expected index and port")
+	}
+	input, err := strconv.Atoi(args[0])
+	if err != nil || input < 0 {
+		return fmt.Errorf("invalid input index %q", args[0])
+	}
+	s, port, err := parseEndpoint(n, args[1])
+	if err != nil {
+		return err
+	}
+	return n.AttachInput(input, s, port)
+}
@@ -0,0 +1,65 @@
+package topo
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/dmage/switchemu/hw"
+)
+
+func TestParse(t *testing.T) {
+	n := NewNetwork(&hw.World{}, hw.NullDropHandler{})
+	err := n.Parse(strings.NewReader(`
+switch leaf1 bandwidth=25G buffer-limit=1000000
+switch spine1 demux-key=dst-prefix/24 hash-seed=7
+link leaf1:up1 spine1:down1 100G 1us
+input 0 leaf1:host0
+input 1 spine1:down2
+`), ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(n.Switches) != 2 {
+		t.Fatalf("got %d switches, want 2", len(n.Switches))
+	}
+	leaf := n.Switch("leaf1")
+	if leaf == nil || leaf.PortBandwidth != 25*1000*1000*1000 {
+		t.Errorf("leaf1: got %+v, want a switch with 25G ports", leaf)
+	}
+	if l := leaf.links["up1"]; l == nil || l.peer != n.Switch("spine1") || l.peerPort != "down1" {
+		t.Errorf("leaf1:up1: got link %+v, want a link to spine1:down1", l)
+	}
+	if l := n.Switch("spine1").links["down1"]; l == nil || l.peer != leaf || l.delay.String() != "1µs" {
+		t.Errorf("spine1:down1: got link %+v, want a link to leaf1:up1 with delay 1µs", l)
+	}
+	for i := 0; i < 2; i++ {
+		if n.Input(i) == nil {
+			t.Errorf("input %d is not attached", i)
+		}
+	}
+	if n.Input(2) != nil {
+		t.Errorf("input 2 is attached")
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, text := range []string{
+		"router r1",
+		"switch",
+		"switch s1\nswitch s1",
+		"switch s1 color=red",
+		"switch s1 bandwidth=fast",
+		"link s1:p1 s2:p1 10G",
+		"switch s1\nswitch s2\nlink s1:p1 s2:p1",
+		"switch s1\nswitch s2\nlink s1:p1 s2:p1 10G\nlink s1:p1 s2:p2 10G",
+		"switch s1\ninput x s1:p1",
+		"switch s1\ninput 0 s1",
+		"switch s1\ninput 0 s1:p1\ninput 0 s1:p2",
+	} {
+		n := NewNetwork(&hw.World{}, hw.NullDropHandler{})
+		if err := n.Parse(strings.NewReader(text), "."); err == nil {
+			t.Errorf("%q: expected an error", text)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package topo
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/dmage/switchemu/hw"
+	"github.com/dmage/switchemu/stat"
+)
+
+// Switch is an output-queued switch of a Network. Packets enter the switch
+// through the handlers returned by Input, are forwarded by Forwarding and
+// leave through egress ports, which are either connected to another switch
+// or deliver packets out of the network.
+//
+// The statistics of a switch are written to files that are prefixed with
+// its name, for example ./output/leaf1.output.buffer_by_time.up1.txt.
+type Switch struct {
+	Name string
+
+	// PortBandwidth is the bandwidth of egress ports that are not
+	// connected to another switch.
+	PortBandwidth int64
+
+	// Forwarding dispatches packets to egress ports.
+	Forwarding hw.Handler
+
+	network *Network
+	hash    *hw.FlowHash
+	buffer  *stat.BufferStatistics
+	drop    hw.Handler
+	inputs  map[string]hw.Handler
+	ports   map[string]hw.Handler
+	links   map[string]*link
+
+	transmitters map[string]*hw.Transmitter
+}
+
+type link struct {
+	bandwidth int64
+	delay     time.Duration
+	peer      *Switch
+	peerPort  string
+}
+
+func newSwitch(n *Network, name string, bufferLimit int, hash *hw.FlowHash) *Switch {
+	s := &Switch{
+		Name:          name,
+		PortBandwidth: 10 * 1000 * 1000 * 1000,
+		network:       n,
+		hash:          hash,
+		buffer:        stat.NewBufferStatistics(name+".total", 100*time.Microsecond),
+		inputs:        make(map[string]hw.Handler),
+		ports:         make(map[string]hw.Handler),
+		links:         make(map[string]*link),
+
+		transmitters: make(map[string]*hw.Transmitter),
+	}
+	s.buffer.BufferLimit = bufferLimit
+	s.buffer.Drop = n.drop
+	s.drop = s.buffer.PortOutput(hw.NullHandler{})
+	n.dump(func() error {
+		err := s.buffer.ByTime.Dump(n.world.StartTime(), s.filename("summary.buffer_by_time"))
+		if err != nil {
+			return err
+		}
+		err = s.buffer.Histogram.Dump(s.filename("summary.buffer_histogram"))
+		if err != nil {
+			return err
+		}
+		return stat.CreateFile(s.filename("summary.dropped"), func(out io.Writer) error {
+			fmt.Fprintf(out, "packets\t%d\n", s.buffer.Dropped)
+			fmt.Fprintf(out, "bytes\t%d\n", s.buffer.DroppedBytes)
+			return nil
+		})
+	})
+	return s
+}
+
+func (s *Switch) filename(parts ...string) string {
+	return fmt.Sprintf("./output/%s.%s.txt", s.Name, strings.Join(parts, "."))
+}
+
+// SetRoutes makes the switch forward packets by the route table. A route
+// may point to a comma-separated list of ports, which forms an ECMP group.
+func (s *Switch) SetRoutes(table *hw.RouteTable) {
+	newOutput := func(name string) hw.Handler {
+		names := strings.Split(name, ",")
+		if len(names) == 1 {
+			return s.Port(name)
+		}
+
+		load := stat.NewMemberLoad(len(names))
+		s.network.dump(func() error {
+			return load.Dump(s.filename("ecmp.load", name))
+		})
+
+		group := hw.NewECMPGroup(name, s.hash)
+		group.Drop = s.buffer.PortDrop(s.network.drop)
+		for i, member := range names {
+			input := s.Port(member)
+			group.AddMember(s.transmitters[member], load.Member(i, input))
+		}
+		return group
+	}
+
+	router := hw.NewRouter(table, newOutput, s.drop)
+	s.network.dump(func() error {
+		return stat.CreateFile(s.filename("summary.unrouted"), func(out io.Writer) error {
+			_, err := fmt.Fprintf(out, "%d\n", router.Unrouted)
+			return err
+		})
+	})
+	s.Forwarding = router
+}
+
+// SetLabels makes the switch forward MPLS packets by the label forwarding
+// table.
+func (s *Switch) SetLabels(table *hw.LFIB) {
+	labelSwitch := hw.NewLabelSwitch(table, s.Port, s.drop)
+	labelSwitch.OnResize = s.buffer.Resize
+	s.network.dump(func() error {
+		return stat.CreateFile(s.filename("summary.lfib"), func(out io.Writer) error {
+			for _, e := range table.Entries {
+				fmt.Fprintf(out, "%d\t%s\t%d\t%s\t%d\t%d\n", e.Label, e.Action, e.OutLabel, e.Port, e.Packets, e.Bytes)
+			}
+			fmt.Fprintf(out, "unlabeled\t%d\n", labelSwitch.Unlabeled)
+			fmt.Fprintf(out, "unknown_label\t%d\n", labelSwitch.UnknownLabel)
+			return nil
+		})
+	})
+	s.Forwarding = labelSwitch
+}
+
+// SetDemux makes the switch create an egress port for every key.
+func (s *Switch) SetDemux(key hw.KeyFunc) {
+	s.Forwarding = hw.NewDemux(key, s.Port, s.drop)
+}
+
+// Input returns the handler for packets that are received on the port.
+func (s *Switch) Input(port string) hw.Handler {
+	if h, ok := s.inputs[port]; ok {
+		return h
+	}
+
+	w := s.network.world
+
+	output := hw.Handler(hw.HandlerFunc(func(w *hw.World, p *hw.Packet) {
+		s.Forwarding.HandlePacket(w, p)
+	}))
+
+	bitsPerSecond := stat.NewBitsPerSecond(time.Second, output)
+	s.network.dump(func() error {
+		return bitsPerSecond.Dump(w, s.filename("input.bits_per_second", port))
+	})
+	output = bitsPerSecond
+
+	packetsPerSecond := stat.NewPacketsPerSecond(time.Second, output)
+	s.network.dump(func() error {
+		return packetsPerSecond.Dump(w, s.filename("input.packets_per_second", port))
+	})
+	output = packetsPerSecond
+
+	output = s.buffer.PortInput(output)
+
+	s.inputs[port] = output
+	return output
+}
+
+// Port returns the egress port with the given name, creating it if
+// necessary.
+func (s *Switch) Port(name string) hw.Handler {
+	if h, ok := s.ports[name]; ok {
+		return h
+	}
+
+	w := s.network.world
+
+	bufferOutput := stat.NewBufferStatistics(s.Name+"."+name, 1*time.Millisecond)
+	bufferOutput.Drop = s.network.drop
+	s.network.dump(func() error {
+		err := bufferOutput.ByTime.Dump(w.StartTime(), s.filename("output.buffer_by_time", name))
+		if err != nil {
+			return err
+		}
+		return bufferOutput.Histogram.Dump(s.filename("output.buffer_histogram", name))
+	})
+
+	output := hw.Handler(hw.NullHandler{})
+	bandwidth := s.PortBandwidth
+	if l, ok := s.links[name]; ok {
+		output = hw.NewLink(l.delay, l.peer.Input(l.peerPort))
+		bandwidth = l.bandwidth
+	}
+
+	output = s.buffer.PortOutput(output)
+
+	output = bufferOutput.PortOutput(output)
+
+	transmitter := hw.NewTransmitter(bandwidth, output)
+	transmitter.Name = s.Name + "." + name
+	transmitter.Drop = s.buffer.PortDrop(bufferOutput.PortDrop(s.network.drop))
+	if s.network.Schedule != nil {
+		s.network.Schedule.Attach(w, transmitter)
+	}
+	output = transmitter
+	s.transmitters[name] = transmitter
+
+	output = bufferOutput.PortInput(output)
+
+	sourceCounter := stat.NewIPSourceCounter(10*time.Millisecond, output)
+	s.network.dump(func() error {
+		return sourceCounter.Dump(w, s.filename("output.sources", name))
+	})
+	output = sourceCounter
+
+	s.ports[name] = output
+	return output
+}